@@ -0,0 +1,143 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "context"
+
+// writeContextChunk is the size of the chunks used by WriteContext, so
+// that a cancellation can stop the transmission between two chunks.
+const writeContextChunk = 256
+
+// OpenPortContext works like OpenPort, but returns ctx.Err() if the
+// context is canceled before the port is opened. This is useful with
+// devices whose open blocks until a connection is made, like the bluetooth
+// RFCOMM ports on linux. If the open completes after the cancellation, the
+// port is closed automatically.
+func OpenPortContext(ctx context.Context, portName string, mode *Mode) (*SerialPort, error) {
+	type result struct {
+		port *SerialPort
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		port, err := OpenPort(portName, mode)
+		res <- result{port, err}
+	}()
+	select {
+	case r := <-res:
+		return r.port, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-res; r.port != nil {
+				r.port.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// ReadContext works like Read, but it returns ctx.Err() if the context is
+// canceled before data is received.
+//
+// The cancellation is implemented with CancelIO, so it aborts also the
+// Reads and Writes made concurrently by other goroutines on the same port.
+func (port *SerialPort) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return port.withContext(ctx, func() (int, error) {
+		return port.Read(p)
+	})
+}
+
+// ReadInto reads the port in background and delivers the data received by
+// each Read on ch, that is never closed. The reception stops when ctx is
+// canceled or a Read fails: the error (ctx.Err() after a cancellation) is
+// then sent on the returned channel, that is closed afterwards. The
+// delivery on ch waits for the receiver, so a slow receiver slows down the
+// reception but no data is lost. The port must not be read by others
+// until the reception stops.
+func (port *SerialPort) ReadInto(ctx context.Context, ch chan<- []byte) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		buf := make([]byte, 1024)
+		for {
+			n, err := port.ReadContext(ctx, buf)
+			if port.isReadTimeout(err) {
+				err = nil
+			}
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				select {
+				case ch <- data:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	return errc
+}
+
+// WriteContext works like Write, but if the context is canceled before the
+// transmission completes it returns the number of bytes written so far and
+// ctx.Err(). The port remains usable after a canceled write.
+//
+// The cancellation is implemented with CancelIO, so it aborts also the
+// Reads and Writes made concurrently by other goroutines on the same port.
+func (port *SerialPort) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return port.withContext(ctx, func() (int, error) {
+		written := 0
+		for written < len(p) {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			chunk := p[written:]
+			if len(chunk) > writeContextChunk {
+				chunk = chunk[:writeContextChunk]
+			}
+			n, err := port.Write(chunk)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		return written, nil
+	})
+}
+
+// withContext runs op, calling CancelIO if ctx is canceled meanwhile. It
+// returns only after the cancel goroutine is gone, so that a late CancelIO
+// can't abort the operations started after it.
+func (port *SerialPort) withContext(ctx context.Context, op func() (int, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			port.CancelIO()
+		case <-done:
+		}
+	}()
+	n, err := op()
+	close(done)
+	<-stopped
+	if err != nil && ctx.Err() != nil {
+		if serr, ok := err.(*SerialPortError); ok && serr.Code() == ERROR_CANCELED {
+			err = ctx.Err()
+		}
+	}
+	return n, err
+}
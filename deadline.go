@@ -0,0 +1,73 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "net"
+import "sync/atomic"
+import "time"
+
+// SerialPortError implements net.Error so it can be used where a
+// net.Conn deadline error is expected.
+var _ net.Error = &SerialPortError{}
+
+// SetDeadline sets both the read and write deadlines, like in net.Conn.
+func (port *SerialPort) SetDeadline(t time.Time) error {
+	port.readDeadline.Store(t)
+	port.writeDeadline.Store(t)
+	port.deadlineChanged()
+	return nil
+}
+
+// SetReadDeadline sets the time after which a Read fails with an
+// ERROR_TIMEOUT error (whose Timeout method returns true). Unlike the read
+// timeout, the deadline is absolute and applies to all the subsequent Reads
+// until changed. A zero value disables the deadline. As in net.Conn, the
+// new deadline applies also to the Reads already waiting for data.
+func (port *SerialPort) SetReadDeadline(t time.Time) error {
+	port.readDeadline.Store(t)
+	port.deadlineChanged()
+	return nil
+}
+
+// SetWriteDeadline sets the time after which a Write fails with an
+// ERROR_TIMEOUT error. A zero value disables the deadline. The new
+// deadline applies also to the Writes already in progress.
+func (port *SerialPort) SetWriteDeadline(t time.Time) error {
+	port.writeDeadline.Store(t)
+	port.deadlineChanged()
+	return nil
+}
+
+// loadDeadline returns the deadline stored in v, zero if never set
+func loadDeadline(v *atomic.Value) time.Time {
+	deadline, _ := v.Load().(time.Time)
+	return deadline
+}
+
+// readDeadlineOf returns the deadline of a Read: the earliest between
+// deadline and the read deadline of the port (zero for none).
+func (port *SerialPort) readDeadlineOf(deadline time.Time) time.Time {
+	if d := loadDeadline(&port.readDeadline); !d.IsZero() && (deadline.IsZero() || d.Before(deadline)) {
+		return d
+	}
+	return deadline
+}
+
+// applyDeadline returns the time to wait for an operation with the given
+// timeout (0 or less waits forever) and deadline (zero for none). The
+// returned flag is true if the deadline comes first, in that case a non
+// positive duration means that the deadline has already expired.
+func applyDeadline(timeout time.Duration, deadline time.Time) (time.Duration, bool) {
+	if deadline.IsZero() {
+		return timeout, false
+	}
+	remaining := deadline.Sub(time.Now())
+	if timeout > 0 && timeout < remaining {
+		return timeout, false
+	}
+	return remaining, true
+}
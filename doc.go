@@ -26,6 +26,10 @@ GetPortsList function:
 		fmt.Printf("Found port: %v\n", port)
 	}
 
+The names returned are in the same form accepted by OpenPort: full device
+paths on unix (like /dev/ttyUSB0 or /dev/cu.usbserial-A6004HXC on darwin)
+and bare port names on windows (like COM3).
+
 The serial port can be opened with the OpenPort function:
 
 	mode := &serial.Mode{
@@ -48,6 +52,16 @@ The following snippets shows how to declare a configuration for 57600_E71:
 		StopBits: serial.STOPBITS_ONE,
 	}
 
+Alternatively the port can be opened with the Open function, that accepts
+a list of options:
+
+	port, err := serial.Open("/dev/ttyUSB0",
+		serial.WithBaudRate(115200),
+		serial.WithReadTimeout(time.Second))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 The configuration can be changed at any time with the SetMode function:
 
 	err := port.SetMode(mode)
@@ -79,6 +93,12 @@ serial port:
 		fmt.Printf("%v", string(buff[:n]))
 	}
 
+Bluetooth SPP ports on linux (/dev/rfcommN) can be opened like the other
+ports, but their open may block until the bluetooth connection is made:
+OpenPortContext can be used to give up after a timeout. The line settings
+and the modem control lines (DTR, RTS, CTS, ...) are not meaningful on
+RFCOMM ports, so the errors applying the Mode are ignored on open.
+
 This library doesn't make use of cgo and "C" package, so it's a pure go library
 that can be easily cross compiled.
 */
@@ -0,0 +1,136 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "strings"
+import "sync"
+import "time"
+
+// PortDetails contains the informations about a serial port that can be
+// gathered from the operating system without opening it.
+//
+// The Location allows to tell apart identical adapters without a serial
+// number, since it depends on where the adapter is plugged: on linux it's
+// the path of USB bus and hub ports (for example "1-1.3"), on windows the
+// instance path of the USB device (for example
+// "USB\VID_0403&PID_6001\5&1A2B3C&0&3", whose last part is derived from
+// the hub port if the device has no serial number). On darwin it's not
+// available.
+type PortDetails struct {
+	Name         string   // The port name, as accepted by OpenPort
+	IsUSB        bool     // True if the port is an USB-serial adapter
+	VID          string   // USB Vendor ID (hex string, if available)
+	PID          string   // USB Product ID (hex string, if available)
+	SerialNumber string   // USB serial number (if available)
+	Product      string   // USB product name (if available)
+	Manufacturer string   // USB manufacturer name (if available)
+	Driver       string   // Name of the driver handling the port (if available)
+	Chip         ChipType // The chipset of the adapter (see ChipType for more info)
+	StablePath   string   // A name that doesn't change across reboots, accepted by OpenPort (linux only, if available)
+	Location     string   // Physical location of the USB adapter, see above (if available)
+	Busy         bool     // True if the port is in use by another process
+}
+
+// portsListCache holds the last result of GetPortsListCached
+var portsListCache struct {
+	lock    sync.Mutex
+	ports   []string
+	updated time.Time
+}
+
+// GetPortsListCached works like GetPortsList, but returns the result of
+// a previous call if it's not older than maxAge. This reduces the cost of
+// polling the list of ports, for example to keep it updated in a user
+// interface. The errors are not cached, and the concurrent calls that
+// need to refresh the list wait for a single scan.
+func GetPortsListCached(maxAge time.Duration) ([]string, error) {
+	portsListCache.lock.Lock()
+	defer portsListCache.lock.Unlock()
+	if portsListCache.ports == nil || time.Since(portsListCache.updated) > maxAge {
+		ports, err := GetPortsList()
+		if err != nil {
+			return nil, err
+		}
+		portsListCache.ports = ports
+		portsListCache.updated = time.Now()
+	}
+	return append([]string{}, portsListCache.ports...), nil
+}
+
+// FindPortBySerialNumber returns the name of the port provided by the USB
+// adapter with the given serial number. This allows to find an adapter
+// regardless of the name assigned by the operating system, that may
+// change when the adapter is plugged again. An ERROR_INVALID_SERIAL_PORT
+// error is returned if the adapter is not found.
+func FindPortBySerialNumber(serialNumber string) (string, error) {
+	ports, err := GetDetailedPortsList()
+	if err != nil {
+		return "", err
+	}
+	for _, port := range ports {
+		if port.IsUSB && port.SerialNumber == serialNumber {
+			return port.Name, nil
+		}
+	}
+	return "", &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+}
+
+// ChipType identifies the chipset of an USB-serial adapter
+type ChipType int
+
+const (
+	CHIP_UNKNOWN  ChipType = iota // The chipset could not be determined
+	CHIP_FTDI                     // FTDI FT232/FT2232/...
+	CHIP_CP210X                   // Silicon Labs CP210x
+	CHIP_CH340                    // WCH CH340/CH341
+	CHIP_PROLIFIC                 // Prolific PL2303
+	CHIP_CDC_ACM                  // USB CDC-ACM class device (native USB)
+)
+
+func (c ChipType) String() string {
+	switch c {
+	case CHIP_FTDI:
+		return "FTDI"
+	case CHIP_CP210X:
+		return "CP210x"
+	case CHIP_CH340:
+		return "CH340"
+	case CHIP_PROLIFIC:
+		return "Prolific"
+	case CHIP_CDC_ACM:
+		return "CDC-ACM"
+	}
+	return "unknown"
+}
+
+// detectChipType guesses the chipset from the driver name reported by the
+// OS, falling back to the USB Vendor ID.
+func detectChipType(driver, vid string) ChipType {
+	switch strings.ToLower(driver) {
+	case "ftdi_sio", "vcp", "ftdibus":
+		return CHIP_FTDI
+	case "cp210x", "silabser":
+		return CHIP_CP210X
+	case "ch341", "ch341-uart", "ch341ser", "wchusbserial":
+		return CHIP_CH340
+	case "pl2303", "prolificserial":
+		return CHIP_PROLIFIC
+	case "cdc_acm", "usbser", "usbmodem":
+		return CHIP_CDC_ACM
+	}
+	switch strings.ToUpper(vid) {
+	case "0403":
+		return CHIP_FTDI
+	case "10C4":
+		return CHIP_CP210X
+	case "1A86":
+		return CHIP_CH340
+	case "067B":
+		return CHIP_PROLIFIC
+	}
+	return CHIP_UNKNOWN
+}
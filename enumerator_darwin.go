@@ -0,0 +1,57 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "strings"
+import "syscall"
+
+// GetDetailedPortsList returns the list of the available serial ports.
+// The USB details are available only through IOKit, that requires cgo, so
+// on darwin only the port name and a guess of the chipset (based on the
+// naming convention of the drivers) are filled.
+//
+// To fill the Busy flag each port is opened (and immediately closed) in
+// non-blocking mode, without disturbing the processes that are using it.
+func GetDetailedPortsList() ([]*PortDetails, error) {
+	ports, err := GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	details := make([]*PortDetails, 0, len(ports))
+	for _, port := range ports {
+		d := &PortDetails{Name: port}
+		switch {
+		case strings.Contains(port, ".usbserial-"):
+			d.IsUSB = true
+			d.Driver = "usbserial"
+		case strings.Contains(port, ".SLAB_USBtoUART"):
+			d.IsUSB = true
+			d.Driver = "silabser"
+		case strings.Contains(port, ".wchusbserial"):
+			d.IsUSB = true
+			d.Driver = "wchusbserial"
+		case strings.Contains(port, ".usbmodem"):
+			d.IsUSB = true
+			d.Driver = "usbmodem"
+		}
+		d.Chip = detectChipType(d.Driver, "")
+		d.Busy = isPortBusy(port)
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+// isPortBusy checks if the port is opened in exclusive mode by another
+// process
+func isPortBusy(port string) bool {
+	h, err := syscall.Open(port, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err == syscall.EBUSY
+	}
+	syscall.Close(h)
+	return false
+}
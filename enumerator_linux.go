@@ -0,0 +1,95 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+
+// GetDetailedPortsList returns the list of the available serial ports
+// together with the informations about the device, taken from sysfs.
+// The ports are never opened: the Busy flag is set looking for the
+// processes that keep the port open (see PortHolders), so without root
+// privileges only the processes of the current user are detected.
+func GetDetailedPortsList() ([]*PortDetails, error) {
+	ports, err := GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	stable := getStablePaths()
+	details := make([]*PortDetails, 0, len(ports))
+	for _, port := range ports {
+		d := getPortDetails(port)
+		d.StablePath = stable[port]
+		if holders, err := PortHolders(port); err == nil {
+			d.Busy = len(holders) > 0
+		}
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+// getStablePaths maps the ports to the symlinks created by udev in
+// /dev/serial/by-id, or in /dev/serial/by-path for the devices without a
+// serial number.
+func getStablePaths() map[string]string {
+	stable := map[string]string{}
+	for _, dir := range []string{"/dev/serial/by-path", "/dev/serial/by-id"} {
+		links, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			path := filepath.Join(dir, link.Name())
+			if port, err := filepath.EvalSymlinks(path); err == nil {
+				// by-id is scanned last and takes precedence
+				stable[port] = path
+			}
+		}
+	}
+	return stable
+}
+
+func getPortDetails(port string) *PortDetails {
+	details := &PortDetails{Name: port}
+
+	// /sys/class/tty/ttyXXX/device links to the device driving the port
+	device, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", filepath.Base(port), "device"))
+	if err != nil {
+		return details
+	}
+	if driver, err := os.Readlink(filepath.Join(device, "driver")); err == nil {
+		details.Driver = filepath.Base(driver)
+	}
+
+	// Look for the USB device in the parent folders
+	for dir := device; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err != nil {
+			continue
+		}
+		details.IsUSB = true
+		details.VID = readSysfsAttribute(dir, "idVendor")
+		details.PID = readSysfsAttribute(dir, "idProduct")
+		details.SerialNumber = readSysfsAttribute(dir, "serial")
+		details.Product = readSysfsAttribute(dir, "product")
+		details.Manufacturer = readSysfsAttribute(dir, "manufacturer")
+		// the USB devices are named after their bus and port path
+		details.Location = filepath.Base(dir)
+		break
+	}
+	details.Chip = detectChipType(details.Driver, details.VID)
+	return details
+}
+
+func readSysfsAttribute(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,166 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "strings"
+import "syscall"
+import "unsafe"
+
+// GetDetailedPortsList returns the list of the available serial ports.
+// The driver and chipset are derived from the name of the device driving
+// the port (for example \Device\VCP0 for FTDI adapters), the USB
+// details are taken from the devices enumerated by the USB and FTDI bus
+// drivers in the registry.
+//
+// To fill the Busy flag each port is opened (and immediately closed): the
+// ports in use by other processes can not be opened, so they are not
+// disturbed.
+func GetDetailedPortsList() ([]*PortDetails, error) {
+	entries, err := getSerialCommEntries()
+	if err != nil {
+		return nil, err
+	}
+	usb := getUSBPorts()
+	details := make([]*PortDetails, 0, len(entries))
+	for _, entry := range entries {
+		d := &PortDetails{Name: entry.port}
+		d.Driver = strings.TrimRight(strings.TrimPrefix(entry.device, "\\Device\\"), "0123456789")
+		if id, ok := usb[entry.port]; ok {
+			d.IsUSB = true
+			d.VID = id.vid
+			d.PID = id.pid
+			d.SerialNumber = id.serialNumber
+			d.Location = id.location
+		}
+		d.Chip = detectChipType(d.Driver, d.VID)
+		d.IsUSB = d.IsUSB || d.Chip != CHIP_UNKNOWN
+		d.Busy = isPortBusy(entry.port)
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+// isPortBusy checks if the port is opened by another process
+func isPortBusy(port string) bool {
+	h, err := syscall.CreateFile(syscall.StringToUTF16Ptr(devicePath(port)),
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0,
+		nil,
+		syscall.OPEN_EXISTING,
+		0,
+		0)
+	if err != nil {
+		return err == syscall.ERROR_ACCESS_DENIED || err == errSharingViolation
+	}
+	syscall.CloseHandle(h)
+	return false
+}
+
+// usbIdentity identifies the USB device providing a port
+type usbIdentity struct {
+	vid, pid, serialNumber string
+	location               string // device instance path
+}
+
+// getUSBPorts maps the names of the ports provided by USB devices to the
+// identity of the devices. The devices are enumerated in the registry
+// under Enum\USB\VID_xxxx&PID_xxxx\<serial number> and, for the FTDI
+// driver, under Enum\FTDIBUS\VID_xxxx+PID_xxxx+<serial number>\0000.
+func getUSBPorts() map[string]usbIdentity {
+	ports := map[string]usbIdentity{}
+	const enum = "SYSTEM\\CurrentControlSet\\Enum\\"
+	for _, device := range regSubKeys(enum + "USB") {
+		ids := strings.Split(device, "&")
+		if len(ids) != 2 {
+			// skip the interfaces of composite devices (&MI_xx)
+			continue
+		}
+		for _, instance := range regSubKeys(enum + "USB\\" + device) {
+			port := regString(enum+"USB\\"+device+"\\"+instance+"\\Device Parameters", "PortName")
+			if port == "" {
+				continue
+			}
+			id := usbIdentity{
+				vid:      strings.TrimPrefix(ids[0], "VID_"),
+				pid:      strings.TrimPrefix(ids[1], "PID_"),
+				location: "USB\\" + device + "\\" + instance,
+			}
+			if !strings.Contains(instance, "&") {
+				// the instance name is the serial number, unless the
+				// device has none and windows made up an id
+				id.serialNumber = instance
+			}
+			ports[port] = id
+		}
+	}
+	for _, device := range regSubKeys(enum + "FTDIBUS") {
+		ids := strings.Split(device, "+")
+		if len(ids) != 3 {
+			continue
+		}
+		port := regString(enum+"FTDIBUS\\"+device+"\\0000\\Device Parameters", "PortName")
+		if port == "" {
+			continue
+		}
+		ports[port] = usbIdentity{
+			vid:          strings.TrimPrefix(ids[0], "VID_"),
+			pid:          strings.TrimPrefix(ids[1], "PID_"),
+			serialNumber: ftdiSerialNumber(ids[2]),
+			location:     "FTDIBUS\\" + device + "\\0000",
+		}
+	}
+	return ports
+}
+
+// ftdiSerialNumber returns the serial number from the last part of the
+// name of an FTDIBUS device. The FTDI driver appends the channel letter
+// to the serial number: the A of the first channel is removed, while the
+// letters of the other channels (B, C, D) are kept so that the ports of a
+// multi-channel adapter have distinct serial numbers. A device without
+// serial number has an id made up by windows, that contains '&'.
+func ftdiSerialNumber(id string) string {
+	if strings.Contains(id, "&") || len(id) < 2 {
+		return ""
+	}
+	return strings.TrimSuffix(id, "A")
+}
+
+// regSubKeys returns the names of the sub keys of an HKEY_LOCAL_MACHINE key
+func regSubKeys(path string) []string {
+	var h syscall.Handle
+	if syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(path), 0, syscall.KEY_READ, &h) != nil {
+		return nil
+	}
+	defer syscall.RegCloseKey(h)
+	keys := []string{}
+	name := make([]uint16, 256)
+	for i := uint32(0); ; i++ {
+		nameSize := uint32(len(name))
+		if syscall.RegEnumKeyEx(h, i, &name[0], &nameSize, nil, nil, nil, nil) != nil {
+			return keys
+		}
+		keys = append(keys, syscall.UTF16ToString(name[:nameSize]))
+	}
+}
+
+// regString returns a string value of an HKEY_LOCAL_MACHINE key, or an
+// empty string if the value doesn't exist
+func regString(path, value string) string {
+	var h syscall.Handle
+	if syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(path), 0, syscall.KEY_READ, &h) != nil {
+		return ""
+	}
+	defer syscall.RegCloseKey(h)
+	data := make([]uint16, 256)
+	var valueType uint32
+	dataSize := uint32(len(data) * 2) // in bytes
+	err := syscall.RegQueryValueEx(h, syscall.StringToUTF16Ptr(value), nil, &valueType, (*byte)(unsafe.Pointer(&data[0])), &dataSize)
+	if err != nil || valueType != syscall.REG_SZ {
+		return ""
+	}
+	return syscall.UTF16ToString(data[:dataSize/2])
+}
@@ -0,0 +1,13 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+// ProcessInfo identifies a process that keeps a serial port open
+type ProcessInfo struct {
+	PID  int    // The process ID
+	Name string // The name of the executable (if available)
+}
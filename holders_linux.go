@@ -0,0 +1,55 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+// PortHolders returns the list of the processes that have the port open,
+// it's useful to find out who is keeping a port busy. The file descriptors
+// of the processes owned by other users can be inspected only by root, so
+// without privileges these processes are silently skipped.
+func PortHolders(portName string) ([]ProcessInfo, error) {
+	device, err := os.Stat(portName)
+	if err != nil {
+		return nil, &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+	}
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, &SerialPortError{code: ERROR_ENUMERATING_PORTS}
+	}
+
+	holders := []ProcessInfo{}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || !proc.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// The process is gone or not accessible
+			continue
+		}
+		for _, fd := range fds {
+			info, err := os.Stat(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !os.SameFile(device, info) {
+				continue
+			}
+			holder := ProcessInfo{PID: pid}
+			if comm, err := ioutil.ReadFile(filepath.Join("/proc", proc.Name(), "comm")); err == nil {
+				holder.Name = strings.TrimSpace(string(comm))
+			}
+			holders = append(holders, holder)
+			break
+		}
+	}
+	return holders, nil
+}
@@ -0,0 +1,12 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+// PortHolders is not supported on windows.
+func PortHolders(portName string) ([]ProcessInfo, error) {
+	return nil, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
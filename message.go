@@ -0,0 +1,70 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "context"
+import "time"
+
+// maxMessageSize is the size of the buffer used by Messages, longer
+// messages are split
+const maxMessageSize = 4096
+
+// SetMessageMode makes each Read return a single message, for the devices
+// that separate the messages with an idle line instead of a delimiter:
+// Read waits for the first byte of a message and returns when no other
+// byte is received for idleGap, or the buffer is full. It's equivalent to
+// SetTimeouts with only ReadInterval set, so it replaces the read timeouts.
+// A read buffer (see SetReadBufferSize) would mix up the messages, so it
+// must be disabled.
+//
+// The idle gap depends on the baud rate and on the protocol: it must be
+// longer than the pauses the device may leave between the characters of a
+// message, and shorter than the pause between two messages. The time to
+// transmit a character is (1 + data bits + parity + stop bits) / baud
+// rate, about 1ms at 9600 baud with 8N1: Modbus RTU, for example,
+// separates the frames with 3.5 characters. Take into account that the
+// gap is measured with a resolution of 1ms or worse, and that the USB
+// adapters deliver the data in packets, every 1ms to 16ms (see
+// SetLatencyTimer): at high baud rates the gap should be no shorter than
+// the latency of the adapter.
+func (port *SerialPort) SetMessageMode(idleGap time.Duration) error {
+	if idleGap <= 0 {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid idle gap"}
+	}
+	return port.SetTimeouts(Timeouts{ReadInterval: idleGap})
+}
+
+// Messages reads the port in background and delivers each Read on the
+// returned channel, to be used with SetMessageMode. The messages longer
+// than 4096 bytes are split. The channel is closed when ctx is canceled or
+// a Read fails.
+func (port *SerialPort) Messages(ctx context.Context) <-chan []byte {
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, err := port.ReadContext(ctx, buf)
+			if port.isReadTimeout(err) {
+				err = nil
+			}
+			if n > 0 {
+				msg := make([]byte, n)
+				copy(msg, buf[:n])
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return messages
+}
@@ -0,0 +1,193 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "fmt"
+import "strconv"
+import "strings"
+import "sync/atomic"
+
+var parityLetters = map[byte]Parity{
+	'N': PARITY_NONE,
+	'O': PARITY_ODD,
+	'E': PARITY_EVEN,
+	'M': PARITY_MARK,
+	'S': PARITY_SPACE,
+}
+
+var stopBitsStrings = map[string]StopBits{
+	"1":   STOPBITS_ONE,
+	"1.5": STOPBITS_ONEPOINTFIVE,
+	"2":   STOPBITS_TWO,
+}
+
+// ParseMode parses a mode string in the classic "baud,bits,parity,stop"
+// notation and returns the corresponding Mode. The fields may be separated
+// by commas, dashes, underscores, slashes, colons or spaces, and the last
+// three may be written together. For example all the following strings
+// are accepted:
+//
+//	"115200,8,N,1"  "9600,7,E,2"  "115200-8N1"  "57600_E71"  "19200 8O1.5"
+//
+// The parity letters are N (none), O (odd), E (even), M (mark) and S
+// (space). Omitted fields are left to the default value.
+func ParseMode(s string) (*Mode, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(",-_/: ", r)
+	})
+	if len(fields) == 0 {
+		return nil, invalidModeString(s, "empty string")
+	}
+	mode := &Mode{}
+	baud, err := strconv.Atoi(fields[0])
+	if err != nil || baud <= 0 {
+		return nil, invalidModeString(s, "invalid baud rate")
+	}
+	mode.BaudRate = baud
+
+	var bits, parity, stop string
+	switch len(fields) {
+	case 1:
+		return mode, nil
+	case 2:
+		// compact form: 8N1 or N81
+		c := strings.ToUpper(fields[1])
+		if len(c) < 3 {
+			return nil, invalidModeString(s, "invalid format")
+		}
+		if c[0] >= '0' && c[0] <= '9' {
+			bits, parity = c[0:1], c[1:2]
+		} else {
+			parity, bits = c[0:1], c[1:2]
+		}
+		stop = c[2:]
+	case 4:
+		bits, parity, stop = fields[1], strings.ToUpper(fields[2]), fields[3]
+	default:
+		return nil, invalidModeString(s, "invalid format")
+	}
+
+	if mode.DataBits, err = strconv.Atoi(bits); err != nil || mode.DataBits < 5 || mode.DataBits > 8 {
+		return nil, invalidModeString(s, "invalid data bits")
+	}
+	var ok bool
+	if len(parity) != 1 {
+		return nil, invalidModeString(s, "invalid parity")
+	}
+	if mode.Parity, ok = parityLetters[parity[0]]; !ok {
+		return nil, invalidModeString(s, "invalid parity")
+	}
+	if mode.StopBits, ok = stopBitsStrings[stop]; !ok {
+		return nil, invalidModeString(s, "invalid stop bits")
+	}
+	return mode, nil
+}
+
+func invalidModeString(s, reason string) error {
+	return &SerialPortError{code: ERROR_OTHER, err: fmt.Sprintf("invalid mode string %q: %s", s, reason)}
+}
+
+// String returns the mode in the "baud,bits,parity,stop" notation (for
+// example "115200,8,N,1"), that is accepted by ParseMode. The default
+// values are shown for the fields left to zero.
+func (m *Mode) String() string {
+	baud := m.BaudRate
+	if baud == 0 {
+		baud = 9600
+	}
+	bits := m.DataBits
+	if bits == 0 {
+		bits = 8
+	}
+	parity := "?"
+	for letter, p := range parityLetters {
+		if p == m.Parity {
+			parity = string(letter)
+		}
+	}
+	stop := "?"
+	for str, sb := range stopBitsStrings {
+		if sb == m.StopBits {
+			stop = str
+		}
+	}
+	return fmt.Sprintf("%d,%d,%s,%s", baud, bits, parity, stop)
+}
+
+// NearestBaud returns the baud rate closest to target that a UART with
+// the given input clock (in Hz) can generate, and its error in percent
+// relative to target. The UART is assumed to divide the clock by 16 and
+// then by an integer divisor, like the 16550 family (a clock of 1843200
+// gives a maximum of 115200 baud). An error within about 2% is usually
+// tolerated by the receivers. If target or baseClock are not positive,
+// 0 is returned.
+func NearestBaud(target, baseClock int) (actual int, errPercent float64) {
+	if target <= 0 || baseClock <= 0 {
+		return 0, 0
+	}
+	// try the divisors giving the rates just above and just below target
+	divisor := baseClock / (16 * target)
+	for _, d := range []int{divisor, divisor + 1} {
+		if d < 1 {
+			continue
+		}
+		rate := baseClock / (16 * d)
+		if actual == 0 || abs(rate-target) < abs(actual-target) {
+			actual = rate
+		}
+	}
+	errPercent = float64(actual-target) * 100 / float64(target)
+	return actual, errPercent
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// SetStrictMode sets if SetMode checks that the driver applied the
+// requested settings: some drivers silently replace the settings they
+// don't support (for example 7 data bits with 8). When enabled, after
+// applying a Mode the settings are read back (see GetMode) and, if the
+// baud rate, data bits, parity, stop bits or flow control differ from
+// the requested ones, SetMode returns an ERROR_MODE_MISMATCH error. The
+// settings applied by the driver are left in place, so they can be
+// inspected with GetMode. It's disabled by default.
+func (port *SerialPort) SetStrictMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&port.strictMode, v)
+}
+
+// checkAppliedMode compares the settings applied to the port with the
+// requested ones, if the strict mode is enabled
+func (port *SerialPort) checkAppliedMode(requested *Mode) error {
+	if atomic.LoadInt32(&port.strictMode) == 0 {
+		return nil
+	}
+	applied, err := port.GetMode()
+	if err != nil {
+		return err
+	}
+	want := *requested
+	if want.BaudRate == 0 {
+		want.BaudRate = 9600
+	}
+	if want.DataBits == 0 {
+		want.DataBits = 8
+	}
+	if applied.BaudRate != want.BaudRate || applied.DataBits != want.DataBits ||
+		applied.Parity != want.Parity || applied.StopBits != want.StopBits ||
+		applied.FlowControl != want.FlowControl {
+		return &SerialPortError{code: ERROR_MODE_MISMATCH}
+	}
+	return nil
+}
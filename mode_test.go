@@ -0,0 +1,102 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "math"
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		mode *Mode // nil if s is invalid
+	}{
+		{"115200,8,N,1", &Mode{BaudRate: 115200, DataBits: 8, Parity: PARITY_NONE, StopBits: STOPBITS_ONE}},
+		{"9600,7,E,2", &Mode{BaudRate: 9600, DataBits: 7, Parity: PARITY_EVEN, StopBits: STOPBITS_TWO}},
+		{"9600,7,e,2", &Mode{BaudRate: 9600, DataBits: 7, Parity: PARITY_EVEN, StopBits: STOPBITS_TWO}},
+		{"115200-8N1", &Mode{BaudRate: 115200, DataBits: 8, Parity: PARITY_NONE, StopBits: STOPBITS_ONE}},
+		{"57600_E71", &Mode{BaudRate: 57600, DataBits: 7, Parity: PARITY_EVEN, StopBits: STOPBITS_ONE}},
+		{"19200 8O1.5", &Mode{BaudRate: 19200, DataBits: 8, Parity: PARITY_ODD, StopBits: STOPBITS_ONEPOINTFIVE}},
+		{"1200/5/M/1.5", &Mode{BaudRate: 1200, DataBits: 5, Parity: PARITY_MARK, StopBits: STOPBITS_ONEPOINTFIVE}},
+		{"300:6:S:1", &Mode{BaudRate: 300, DataBits: 6, Parity: PARITY_SPACE, StopBits: STOPBITS_ONE}},
+		{"4800", &Mode{BaudRate: 4800}},
+		{"", nil},
+		{",,,", nil},
+		{"fast", nil},
+		{"96OO,8,N,1", nil},
+		{"0,8,N,1", nil},
+		{"9600,8N", nil},
+		{"9600,8,N", nil},
+		{"9600,8,N,1,1", nil},
+		{"9600,9,N,1", nil},
+		{"9600,4,N,1", nil},
+		{"9600,x,N,1", nil},
+		{"9600,8,X,1", nil},
+		{"9600,8,NN,1", nil},
+		{"9600,8,N,3", nil},
+		{"9600-8N3", nil},
+		{"9600-XN1", nil},
+	}
+	for _, test := range tests {
+		mode, err := ParseMode(test.s)
+		if test.mode == nil {
+			if err == nil {
+				t.Errorf("ParseMode(%q) returned %+v, want an error", test.s, mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMode(%q) returned %v", test.s, err)
+		} else if *mode != *test.mode {
+			t.Errorf("ParseMode(%q) returned %+v, want %+v", test.s, mode, test.mode)
+		}
+	}
+}
+
+func TestModeString(t *testing.T) {
+	tests := []struct {
+		mode *Mode
+		s    string
+	}{
+		{&Mode{}, "9600,8,N,1"},
+		{&Mode{BaudRate: 115200, DataBits: 7, Parity: PARITY_EVEN, StopBits: STOPBITS_TWO}, "115200,7,E,2"},
+		{&Mode{BaudRate: 50, DataBits: 5, Parity: PARITY_MARK, StopBits: STOPBITS_ONEPOINTFIVE}, "50,5,M,1.5"},
+	}
+	for _, test := range tests {
+		s := test.mode.String()
+		if s != test.s {
+			t.Errorf("String of %+v returned %q, want %q", test.mode, s, test.s)
+		}
+		if mode, err := ParseMode(s); err != nil || mode.String() != s {
+			t.Errorf("ParseMode(%q) returned (%v, %v), want the same mode", s, mode, err)
+		}
+	}
+}
+
+func TestNearestBaud(t *testing.T) {
+	tests := []struct {
+		target, baseClock int
+		actual            int
+		errPercent        float64
+	}{
+		{115200, 1843200, 115200, 0},
+		{9600, 1843200, 9600, 0},
+		{300, 1843200, 300, 0},
+		{31250, 16000000, 31250, 0},
+		{115200, 48000000, 115384, 0.1597},
+		{57600, 16000000, 58823, 2.1233},  // 55555 with the next divisor
+		{250000, 1843200, 115200, -53.92}, // above the maximum rate
+		{0, 1843200, 0, 0},
+		{9600, 0, 0, 0},
+		{-9600, 1843200, 0, 0},
+	}
+	for _, test := range tests {
+		actual, errPercent := NearestBaud(test.target, test.baseClock)
+		if actual != test.actual || math.Abs(errPercent-test.errPercent) > 0.001 {
+			t.Errorf("NearestBaud(%d, %d) = (%d, %.4f), want (%d, %.4f)", test.target, test.baseClock, actual, errPercent, test.actual, test.errPercent)
+		}
+	}
+}
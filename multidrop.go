@@ -0,0 +1,57 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+// Write9Bit transmits data on a multidrop bus that uses the 9th bit to
+// mark the address bytes: the bytes whose flag in addressFlags is true are
+// sent with the 9th bit set. The 9th bit is emulated with the parity bit,
+// switching between PARITY_MARK and PARITY_SPACE: the parity can't change
+// while a byte is being transmitted, so before each change Write9Bit waits
+// for the data to be sent (see Drain). The best throughput is obtained
+// grouping the data bytes after their address byte. When it returns all
+// the data has been transmitted and the Mode of the port is restored.
+//
+// The mark and space parity are not supported on darwin.
+func (port *SerialPort) Write9Bit(data []byte, addressFlags []bool) (err error) {
+	if len(addressFlags) != len(data) {
+		return &SerialPortError{code: ERROR_OTHER, err: "data and address flags have different lengths"}
+	}
+	if !markSpaceParity {
+		return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	original := port.mode
+	defer func() {
+		if rerr := port.SetMode(&original); err == nil {
+			err = rerr
+		}
+	}()
+	mode := original
+	for start := 0; start < len(data); {
+		end := start + 1
+		for end < len(data) && addressFlags[end] == addressFlags[start] {
+			end++
+		}
+		parity := PARITY_SPACE
+		if addressFlags[start] {
+			parity = PARITY_MARK
+		}
+		if mode.Parity != parity {
+			if err := port.Drain(); err != nil {
+				return err
+			}
+			mode.Parity = parity
+			if err := port.SetMode(&mode); err != nil {
+				return err
+			}
+		}
+		if _, err := port.Write(data[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return port.Drain()
+}
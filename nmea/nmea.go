@@ -0,0 +1,183 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package nmea decodes the stream of NMEA 0183 sentences sent by GPS
+receivers and similar devices. The Decoder reads from any io.Reader, so it
+can be used directly on a serial port:
+
+	port, err := serial.OpenPort("/dev/ttyUSB0", &serial.Mode{BaudRate: 4800})
+	if err != nil {
+		log.Fatal(err)
+	}
+	dec := nmea.NewDecoder(port)
+	for s := range dec.Sentences() {
+		fmt.Println(s.Address, s.Fields)
+	}
+	log.Fatal(dec.Err())
+
+The partial and corrupted lines are discarded silently.
+*/
+package nmea
+
+import "bytes"
+import "errors"
+import "io"
+import "strconv"
+import "strings"
+
+// maxLineLength is the length after which an unterminated line is
+// considered garbage and discarded (the NMEA limit is 82 characters).
+const maxLineLength = 1024
+
+var (
+	// ErrInvalidSentence is returned by Parse if the line is not an NMEA
+	// sentence
+	ErrInvalidSentence = errors.New("nmea: invalid sentence")
+
+	// ErrChecksum is returned by Parse if the checksum doesn't match
+	ErrChecksum = errors.New("nmea: checksum mismatch")
+)
+
+// Sentence is a decoded NMEA sentence
+type Sentence struct {
+	Raw     string   // The whole sentence, without the line terminator
+	Address string   // The talker and sentence type, like "GPGGA"
+	Fields  []string // The comma separated data fields
+}
+
+// Talker returns the talker identifier (like "GP" for GPS), or an empty
+// string for the proprietary sentences.
+func (s *Sentence) Talker() string {
+	if strings.HasPrefix(s.Address, "P") || len(s.Address) < 2 {
+		return ""
+	}
+	return s.Address[:2]
+}
+
+// Type returns the sentence type (like "GGA").
+func (s *Sentence) Type() string {
+	if strings.HasPrefix(s.Address, "P") || len(s.Address) < 2 {
+		return s.Address
+	}
+	return s.Address[2:]
+}
+
+// Parse decodes a single NMEA sentence. If verifyChecksum is true the
+// checksum is required and verified, otherwise it's optional and ignored.
+func Parse(line string, verifyChecksum bool) (*Sentence, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 2 || (line[0] != '$' && line[0] != '!') {
+		return nil, ErrInvalidSentence
+	}
+	data := line[1:]
+	if i := strings.LastIndexByte(data, '*'); i >= 0 {
+		if verifyChecksum {
+			sum, err := strconv.ParseUint(data[i+1:], 16, 8)
+			if err != nil || len(data)-i != 3 {
+				return nil, ErrInvalidSentence
+			}
+			if byte(sum) != checksum(data[:i]) {
+				return nil, ErrChecksum
+			}
+		}
+		data = data[:i]
+	} else if verifyChecksum {
+		return nil, ErrInvalidSentence
+	}
+	fields := strings.Split(data, ",")
+	if fields[0] == "" {
+		return nil, ErrInvalidSentence
+	}
+	return &Sentence{Raw: line, Address: fields[0], Fields: fields[1:]}, nil
+}
+
+// checksum computes the NMEA checksum of data, the xor of all the bytes
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum ^= data[i]
+	}
+	return sum
+}
+
+// Decoder reads NMEA sentences from a stream
+type Decoder struct {
+	// VerifyChecksum makes the Decoder discard the sentences without a
+	// valid checksum. It's true by default.
+	VerifyChecksum bool
+
+	r   io.Reader
+	buf []byte
+	tmp [256]byte
+	err error
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{VerifyChecksum: true, r: r}
+}
+
+// Decode returns the next valid sentence, discarding the lines that are
+// not valid sentences. An error is returned only if the underlying reader
+// fails. The reads returning no data (for example because of a read
+// timeout on a serial port) are retried.
+func (d *Decoder) Decode() (*Sentence, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if s, err := Parse(line, d.VerifyChecksum); err == nil {
+			return s, nil
+		}
+	}
+}
+
+// Sentences starts decoding the stream in a goroutine and returns a
+// channel receiving the sentences. The channel is closed when the
+// underlying reader fails (for example when the serial port is closed),
+// the error is returned by Err.
+func (d *Decoder) Sentences() <-chan *Sentence {
+	ch := make(chan *Sentence)
+	go func() {
+		defer close(ch)
+		for {
+			s, err := d.Decode()
+			if err != nil {
+				d.err = err
+				return
+			}
+			ch <- s
+		}
+	}()
+	return ch
+}
+
+// Err returns the error that stopped the decoding started with Sentences,
+// it must be called after the channel has been closed.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// readLine returns the next line, without the line terminator
+func (d *Decoder) readLine() (string, error) {
+	for {
+		if i := bytes.IndexByte(d.buf, '\n'); i >= 0 {
+			line := string(d.buf[:i])
+			d.buf = append(d.buf[:0], d.buf[i+1:]...)
+			return line, nil
+		}
+		if len(d.buf) > maxLineLength {
+			d.buf = d.buf[:0]
+		}
+		n, err := d.r.Read(d.tmp[:])
+		d.buf = append(d.buf, d.tmp[:n]...)
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "time"
+
+// Option is a configuration setting for a serial port opened with Open.
+type Option func(*openOptions)
+
+type openOptions struct {
+	mode        Mode
+	access      AccessMode
+	readTimeout time.Duration
+	timeoutErr  bool
+	dtr, rts    *bool
+
+	preserveSettings bool
+	exclusive        bool
+	errorPolicy      ErrorPolicy
+	strictMode       bool
+}
+
+// WithBaudRate sets the serial port bitrate
+func WithBaudRate(baudRate int) Option {
+	return func(o *openOptions) { o.mode.BaudRate = baudRate }
+}
+
+// WithDataBits sets the size of the character (5, 6, 7 or 8)
+func WithDataBits(dataBits int) Option {
+	return func(o *openOptions) { o.mode.DataBits = dataBits }
+}
+
+// WithParity sets the parity
+func WithParity(parity Parity) Option {
+	return func(o *openOptions) { o.mode.Parity = parity }
+}
+
+// WithStopBits sets the number of stop bits
+func WithStopBits(stopBits StopBits) Option {
+	return func(o *openOptions) { o.mode.StopBits = stopBits }
+}
+
+// WithFlowControl sets the flow control
+func WithFlowControl(flow FlowControl) Option {
+	return func(o *openOptions) { o.mode.FlowControl = flow }
+}
+
+// WithMode sets all the parameters contained in the given Mode
+func WithMode(mode *Mode) Option {
+	return func(o *openOptions) { o.mode = *mode }
+}
+
+// WithAccessMode opens the port only for reading or only for writing
+func WithAccessMode(access AccessMode) Option {
+	return func(o *openOptions) { o.access = access }
+}
+
+// WithReadTimeout sets the read timeout (see SetReadTimeout)
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *openOptions) { o.readTimeout = timeout }
+}
+
+// WithReadTimeoutError makes a Read whose timeout expires return an
+// ERROR_TIMEOUT error (see SetReadTimeoutError)
+func WithReadTimeoutError() Option {
+	return func(o *openOptions) { o.timeoutErr = true }
+}
+
+// WithDTR sets the level of the DTR line after open
+func WithDTR(level bool) Option {
+	return func(o *openOptions) { o.dtr = &level }
+}
+
+// WithRTS sets the level of the RTS line after open
+func WithRTS(level bool) Option {
+	return func(o *openOptions) { o.rts = &level }
+}
+
+// WithPreserveSettings opens the port without changing its settings, as
+// they were left by the previous user of the port: the options setting
+// the Mode (like WithBaudRate) are ignored, and Reset keeps the settings
+// of the port too. The settings in use can be read with GetMode. On
+// windows the timeouts of the driver (COMMTIMEOUTS) are still set, since
+// Read and Write rely on them.
+func WithPreserveSettings() Option {
+	return func(o *openOptions) { o.preserveSettings = true }
+}
+
+// WithExclusiveAccess sets if the port is opened in exclusive mode. On
+// unix the ports are not exclusive by default, so they can be shared, for
+// example with a monitoring tool. The exclusive mode is set with
+// TIOCEXCL: the opens of the port that follow fail with ERROR_PORT_BUSY,
+// unless made by root. It's advisory and doesn't affect the processes
+// that already opened the port. O_EXCL instead has no effect on tty
+// devices. On windows the serial ports are always opened in exclusive
+// mode.
+func WithExclusiveAccess(exclusive bool) Option {
+	return func(o *openOptions) { o.exclusive = exclusive }
+}
+
+// WithStrictMode makes the port check that the driver applied the
+// requested Mode, at open and in the following SetModes (see
+// SetStrictMode).
+func WithStrictMode() Option {
+	return func(o *openOptions) { o.strictMode = true }
+}
+
+// WithErrorPolicy sets what happens to the port when the device stops
+// working (see ErrorPolicy and SetDisconnectProbe). The policy also
+// applies to the settings made by Open after the port is opened: with
+// ERRORPOLICY_PROPAGATE the port is returned together with the error,
+// otherwise it's closed.
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(o *openOptions) { o.errorPolicy = policy }
+}
+
+// Open opens the serial port and configures it with the given options,
+// the settings not specified are left to their defaults (9600_N81, no
+// flow control, no read timeout). For example:
+//
+//	port, err := serial.Open("/dev/ttyUSB0",
+//		serial.WithBaudRate(115200),
+//		serial.WithFlowControl(serial.FLOWCONTROL_RTSCTS),
+//		serial.WithReadTimeout(time.Second))
+//
+// If any of the settings fails the port is closed and the error returned
+// (see WithErrorPolicy).
+func Open(portName string, options ...Option) (*SerialPort, error) {
+	opts := &openOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	port, err := openSerialPort(portName, opts)
+	if err != nil {
+		return nil, withPortName("open", portName, err)
+	}
+	port.SetErrorPolicy(opts.errorPolicy)
+	if err := opts.apply(port); err != nil {
+		err = withPortName("open", portName, err)
+		if opts.errorPolicy == ERRORPOLICY_PROPAGATE {
+			return port, err
+		}
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// apply applies the settings that are not part of the Mode
+func (o *openOptions) apply(port *SerialPort) error {
+	if o.strictMode {
+		port.SetStrictMode(true)
+		if !o.preserveSettings {
+			if err := port.checkAppliedMode(&o.mode); err != nil {
+				return err
+			}
+		}
+	}
+	if o.readTimeout != 0 {
+		if err := port.SetReadTimeout(o.readTimeout); err != nil {
+			return err
+		}
+	}
+	port.SetReadTimeoutError(o.timeoutErr)
+	if o.dtr != nil {
+		if err := port.SetDTR(*o.dtr); err != nil {
+			return err
+		}
+	}
+	if o.rts != nil {
+		if err := port.SetRTS(*o.rts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "bytes"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// Stores data received from the serial port into the provided byte array
+// buffer. The function returns the number of bytes read.
+//
+// The Read function blocks until (at least) one byte is received from
+// the serial port or an error occurs. If a read timeout is set (see
+// SetReadTimeout) and it expires, Read returns 0 bytes and no error: it
+// means that no data was received and the Read can be retried, not the end
+// of the data. To get an ERROR_TIMEOUT error instead, see
+// SetReadTimeoutError. Without a read timeout an idle line keeps Read
+// waiting. The same holds on all the platforms:
+//
+//   - an idle line: Read waits, or returns 0 bytes and no error when the
+//     read timeout expires (ErrTimeout if SetReadTimeoutError is enabled)
+//   - an expired deadline (see SetReadDeadline): ErrTimeout
+//   - an unplugged device: ErrPortDisconnected (see SetDisconnectProbe)
+//   - a closed port: ErrPortClosed
+//
+// Read never returns io.EOF, since a serial line has no end of data.
+//
+// If the overrun detection is enabled (see EnableOverrunDetection) and
+// some received data has been lost, Read returns the bytes read together
+// with an ERROR_RX_OVERRUN error.
+func (port *SerialPort) Read(p []byte) (int, error) {
+	return port.readWith(p, readOptions{})
+}
+
+// readOptions changes the behavior of a single Read, without touching the
+// settings of the port that may be in use by other goroutines
+type readOptions struct {
+	// deadline replaces the read deadline of the port, if earlier
+	deadline time.Time
+
+	// timeout replaces the read timeouts of the port if timeoutSet is true
+	timeout    time.Duration
+	timeoutSet bool
+}
+
+// readWith implements Read with the given options
+func (port *SerialPort) readWith(p []byte, opts readOptions) (int, error) {
+	if port.access == ACCESS_WRITE_ONLY {
+		return 0, &SerialPortError{code: ERROR_ACCESS_MODE}
+	}
+	if n := port.readBuffer.take(p); n > 0 {
+		return n, nil
+	}
+	if atomic.LoadInt32(&port.faulted) != 0 {
+		return 0, &SerialPortError{code: ERROR_PORT_DISCONNECTED}
+	}
+	if atomic.LoadInt32(&port.latencyEnabled) != 0 {
+		defer port.latency.read.record(time.Now())
+	}
+	buf := p
+	buffered := len(p) > 0 && len(p) < port.readBuffer.size()
+	if buffered {
+		buf = port.readBuffer.buf
+	}
+	if max := int(atomic.LoadInt32(&port.maxReadChunk)); max > 0 && len(buf) > max {
+		buf = buf[:max]
+	}
+	n, err := port.read(buf, opts)
+	port.trace("RX", buf, n)
+	if buffered && n > 0 {
+		port.readBuffer.fill(buf[:n])
+		n = port.readBuffer.take(p)
+	}
+	if n == 0 && err == nil && len(p) > 0 && atomic.LoadInt32(&port.readTimeoutError) != 0 {
+		err = &SerialPortError{code: ERROR_TIMEOUT}
+	}
+	if err == nil && atomic.LoadInt32(&port.overrunDetection) != 0 && port.checkOverrun() {
+		err = &SerialPortError{code: ERROR_RX_OVERRUN}
+	}
+	if err != nil {
+		err = withPortName("read", port.name, port.checkDisconnected(err))
+	}
+	return n, err
+}
+
+// SyncWrite works like Write, but returns only after the data has been
+// transmitted (see Drain). Two consecutive SyncWrites are never merged
+// by the operating system or the adapter, so the gap between them is
+// under control of the caller. Each SyncWrite costs at least the time to
+// transmit the data, plus the latency of the driver to report that the
+// transmission is complete: on USB adapters this may add a few
+// milliseconds.
+func (port *SerialPort) SyncWrite(p []byte) (int, error) {
+	n, err := port.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, port.Drain()
+}
+
+// SetMaxReadChunk limits the number of bytes requested to the operating
+// system by a single Read, regardless of the size of the buffer passed to
+// Read. This bounds the time a Read may wait to fill a large buffer. Zero
+// means no limit (the default).
+func (port *SerialPort) SetMaxReadChunk(n int) error {
+	if n < 0 {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid read chunk size"}
+	}
+	atomic.StoreInt32(&port.maxReadChunk, int32(n))
+	return nil
+}
+
+// SetReadTimeoutError sets if a Read whose timeout expires without
+// receiving data returns an ERROR_TIMEOUT error, instead of 0 bytes and no
+// error (the default). The error implements net.Error, so it can be
+// recognized with its Timeout method. This avoids mistaking a timeout for
+// the end of the data, as some users of io.Reader do.
+func (port *SerialPort) SetReadTimeoutError(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&port.readTimeoutError, v)
+}
+
+// isReadTimeout reports if err is the ERROR_TIMEOUT error returned by a
+// Read whose read timeout expired (see SetReadTimeoutError), rather than
+// by an expired read deadline: the loops reading the port in background
+// retry the Read in that case.
+func (port *SerialPort) isReadTimeout(err error) bool {
+	serr, ok := err.(*SerialPortError)
+	if !ok || !serr.Timeout() {
+		return false
+	}
+	deadline := loadDeadline(&port.readDeadline)
+	return deadline.IsZero() || time.Now().Before(deadline)
+}
+
+// SetDisconnectProbe configures how a failed Read checks if the device is
+// still there: the port is probed up to retries+1 times, waiting delay
+// between each probe. If all the probes fail Read returns an
+// ERROR_PORT_DISCONNECTED error, otherwise the original error. By default
+// the port is not closed, but it's marked as faulted: the following
+// Reads and Writes fail with ERROR_PORT_DISCONNECTED until Recover is
+// called (see SetErrorPolicy). The default is 3 retries every 100ms.
+// The same check is done when SetMode or SetBreak fail. On unix the EIO
+// error of a hung up tty (like an unplugged USB adapter) is reported as a
+// disconnection at once, by Write too.
+func (port *SerialPort) SetDisconnectProbe(retries int, delay time.Duration) {
+	port.probeSettings.Store(disconnectProbe{retries: retries, delay: delay})
+}
+
+// disconnectProbe is the configuration set with SetDisconnectProbe
+type disconnectProbe struct {
+	retries int
+	delay   time.Duration
+}
+
+var defaultDisconnectProbe = disconnectProbe{retries: 3, delay: 100 * time.Millisecond}
+
+// SetErrorPolicy sets what happens to the port when an operation finds
+// out that the device stopped working. With ERRORPOLICY_PROPAGATE the
+// ERROR_PORT_DISCONNECTED error is returned but the port is left as is,
+// so the application can apply its own recovery.
+func (port *SerialPort) SetErrorPolicy(policy ErrorPolicy) {
+	atomic.StoreInt32(&port.errorPolicy, int32(policy))
+}
+
+func (port *SerialPort) checkDisconnected(err error) error {
+	if _, ok := err.(*SerialPortError); ok {
+		// closed, reset, canceled or timed out
+		return err
+	}
+	if isHangup(err) {
+		// the device is certainly gone, don't wait for the probes
+		return port.disconnected()
+	}
+	settings := port.probeSettings.Load().(disconnectProbe)
+	for i := 0; ; i++ {
+		perr := port.probe()
+		if perr == nil {
+			return err
+		}
+		if _, ok := perr.(*SerialPortError); ok {
+			// the port has been closed meanwhile
+			return perr
+		}
+		if i >= settings.retries {
+			return port.disconnected()
+		}
+		time.Sleep(settings.delay)
+	}
+}
+
+// disconnected applies the error policy to a port whose device is gone
+// and returns the corresponding error
+func (port *SerialPort) disconnected() error {
+	switch ErrorPolicy(atomic.LoadInt32(&port.errorPolicy)) {
+	case ERRORPOLICY_FAULT:
+		atomic.StoreInt32(&port.faulted, 1)
+	case ERRORPOLICY_CLOSE:
+		port.Close()
+	}
+	return &SerialPortError{code: ERROR_PORT_DISCONNECTED}
+}
+
+// Send the content of the data byte array to the serial port.
+// Returns the number of bytes written.
+func (port *SerialPort) Write(p []byte) (int, error) {
+	if port.access == ACCESS_READ_ONLY {
+		return 0, &SerialPortError{code: ERROR_ACCESS_MODE}
+	}
+	if atomic.LoadInt32(&port.faulted) != 0 {
+		return 0, &SerialPortError{code: ERROR_PORT_DISCONNECTED}
+	}
+	if atomic.LoadInt32(&port.latencyEnabled) != 0 {
+		defer port.latency.write.record(time.Now())
+	}
+	var n int
+	var err error
+	if rs485, _ := port.rs485.Load().(*manualRS485); rs485 != nil {
+		n, err = port.writeRS485(p, rs485)
+	} else {
+		n, err = port.write(p)
+	}
+	port.trace("TX", p, n)
+	return n, port.writeError(err)
+}
+
+// writeError completes the error of a Write: a hang up is checked as a
+// possible disconnection, and the port name is added
+func (port *SerialPort) writeError(err error) error {
+	if err != nil && isHangup(err) {
+		err = port.checkDisconnected(err)
+	}
+	return withPortName("write", port.name, err)
+}
+
+// Writev sends the content of the buffers as a single Write, without
+// joining them first where the operating system allows it (with writev on
+// unix). This saves a copy when a message is assembled from more slices,
+// like a header and a payload. It returns the total number of bytes
+// written, the timeouts and the deadline apply as for Write. On windows,
+// or when the tracing or the manual RS-485 mode are enabled, the buffers
+// are joined and sent with Write.
+func (port *SerialPort) Writev(bufs [][]byte) (int, error) {
+	rs485, _ := port.rs485.Load().(*manualRS485)
+	if t, _ := port.tracer.Load().(*tracer); t != nil || rs485 != nil {
+		return port.Write(bytes.Join(bufs, nil))
+	}
+	if port.access == ACCESS_READ_ONLY {
+		return 0, &SerialPortError{code: ERROR_ACCESS_MODE}
+	}
+	if atomic.LoadInt32(&port.faulted) != 0 {
+		return 0, &SerialPortError{code: ERROR_PORT_DISCONNECTED}
+	}
+	if atomic.LoadInt32(&port.latencyEnabled) != 0 {
+		defer port.latency.write.record(time.Now())
+	}
+	n, err := port.writev(bufs)
+	return n, port.writeError(err)
+}
+
+// Recover clears the faulted state of a port whose device stopped
+// responding (see SetDisconnectProbe). If the device responds again the
+// port is used as-is, otherwise it's reopened with the same port name and
+// Mode. If the device is still missing the port remains faulted and the
+// error is returned, so Recover can be called again later.
+func (port *SerialPort) Recover() error {
+	if atomic.LoadInt32(&port.faulted) == 0 {
+		return nil
+	}
+	if err := port.probe(); err != nil {
+		if serr, ok := err.(*SerialPortError); ok {
+			// the port has been closed
+			return serr
+		}
+		if err := port.reopen(); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt32(&port.faulted, 0)
+	return nil
+}
+
+// Name returns the name the port has been opened with, as passed to
+// OpenPort (like /dev/ttyUSB0 or COM3). On windows, if the USB adapter
+// has been re-enumerated under a new name and the port has been reopened
+// by Reset or Recover, the new name is returned.
+func (port *SerialPort) Name() string {
+	return port.name
+}
+
+// OpenPorts opens the ports in parallel, all with the same Mode. The
+// returned slices have the same length of names: for each port either the
+// opened port or the error is set, so the ports that opened can be used
+// even if some of the others failed.
+func OpenPorts(names []string, mode *Mode) ([]*SerialPort, []error) {
+	ports := make([]*SerialPort, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			ports[i], errs[i] = OpenPort(name, mode)
+		}(i, name)
+	}
+	wg.Wait()
+	return ports, errs
+}
+
+// WithPort opens the port, calls fn and closes the port, even if fn
+// panics. The first error encountered among opening the port, fn and
+// closing the port is returned.
+func WithPort(portName string, mode *Mode, fn func(*SerialPort) error) (err error) {
+	port, err := OpenPort(portName, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := port.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return fn(port)
+}
+
+// OpenAndVerify opens the port, sends probe and waits for expect to be
+// received within timeout, to check that a device is connected and
+// talking with the given Mode. The data received before expect is
+// discarded, the data following it is left unread. If the port opens but
+// the device doesn't answer, the port is closed and an ERROR_TIMEOUT
+// error is returned, so that it can be told apart from the errors of the
+// open.
+func OpenAndVerify(portName string, mode *Mode, probe, expect []byte, timeout time.Duration) (*SerialPort, error) {
+	port, err := OpenPort(portName, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := port.verify(probe, expect, timeout); err != nil {
+		port.Close()
+		// the errors of the Reads and Writes are reported as verify errors
+		serr := withPortName("verify", portName, err).(*SerialPortError)
+		serr.op = "verify"
+		return nil, serr
+	}
+	return port, nil
+}
+
+// verify discards the stale input, sends probe and waits for expect
+func (port *SerialPort) verify(probe, expect []byte, timeout time.Duration) error {
+	if err := port.ResetInputBuffer(); err != nil {
+		return err
+	}
+	if _, err := port.Write(probe); err != nil {
+		return err
+	}
+	return port.ReadUntilSequence(expect, timeout)
+}
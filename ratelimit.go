@@ -0,0 +1,65 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "context"
+import "time"
+
+// WriteRateLimited writes p one byte at a time, spacing the bytes so that
+// the throughput is bytesPerSecond regardless of the baud rate of the
+// port. The bytes are scheduled from the start of the call, so the time
+// spent in each Write doesn't accumulate. It's useful to simulate a slow
+// sender. The write deadline (see SetWriteDeadline) is honored also while
+// waiting between two bytes: when it expires the number of bytes written
+// so far is returned with an ERROR_TIMEOUT error.
+func (port *SerialPort) WriteRateLimited(p []byte, bytesPerSecond int) (int, error) {
+	return port.WriteRateLimitedContext(context.Background(), p, bytesPerSecond)
+}
+
+// WriteRateLimitedContext works like WriteRateLimited, but if the context
+// is canceled before the transmission completes it returns the number of
+// bytes written so far and ctx.Err().
+func (port *SerialPort) WriteRateLimitedContext(ctx context.Context, p []byte, bytesPerSecond int) (int, error) {
+	if bytesPerSecond <= 0 {
+		return 0, &SerialPortError{code: ERROR_OTHER, err: "Invalid byte rate"}
+	}
+	interval := time.Second / time.Duration(bytesPerSecond)
+	start := time.Now()
+	for i := range p {
+		if err := port.waitRateLimit(ctx, start.Add(time.Duration(i)*interval)); err != nil {
+			return i, err
+		}
+		if _, err := port.WriteContext(ctx, p[i:i+1]); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+// waitRateLimit waits until t, returning early with an error if ctx is
+// canceled or the write deadline expires.
+func (port *SerialPort) waitRateLimit(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	timeout := false
+	if deadline := loadDeadline(&port.writeDeadline); !deadline.IsZero() && deadline.Before(t) {
+		wait = time.Until(deadline)
+		timeout = true
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if timeout {
+		return &SerialPortError{code: ERROR_TIMEOUT}
+	}
+	return ctx.Err()
+}
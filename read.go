@@ -0,0 +1,133 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "bytes"
+import "time"
+
+// ReadUntilSequence reads and discards the incoming data until the byte
+// sequence seq has been received, for example a prompt like "OK\r\n" or
+// a binary sync pattern. The data following seq is left unread. If seq is
+// not received within timeout an ERROR_TIMEOUT error is returned. The
+// read deadline of the port, if earlier, is honored.
+func (port *SerialPort) ReadUntilSequence(seq []byte, timeout time.Duration) error {
+	if len(seq) == 0 {
+		return nil
+	}
+	deadline := port.readDeadlineWithin(timeout)
+
+	window := make([]byte, 0, len(seq))
+	b := make([]byte, 1)
+	for {
+		if err := port.readByte(b, deadline); err != nil {
+			return err
+		}
+		if len(window) == len(seq) {
+			window = append(window[:0], window[1:]...)
+		}
+		window = append(window, b[0])
+		if bytes.Equal(window, seq) {
+			return nil
+		}
+	}
+}
+
+// ReadResponse reads the response to a command, skipping the data that
+// comes before it (like the echo of the command by an AT modem): the
+// incoming data is discarded until expectPrefix is received, then read
+// until terminator. The returned response includes both expectPrefix and
+// terminator, the data following terminator is left unread. If the whole
+// response is not received within timeout an ERROR_TIMEOUT error is
+// returned, together with the partial response if the prefix has been
+// received. As with ReadUntilSequence, the read deadline of the port is
+// honored if earlier.
+func (port *SerialPort) ReadResponse(expectPrefix []byte, terminator []byte, timeout time.Duration) ([]byte, error) {
+	if len(terminator) == 0 {
+		return nil, &SerialPortError{code: ERROR_OTHER, err: "empty response terminator"}
+	}
+	deadline := port.readDeadlineWithin(timeout)
+	if err := port.ReadUntilSequence(expectPrefix, deadline.Sub(time.Now())); err != nil {
+		return nil, err
+	}
+
+	response := append([]byte{}, expectPrefix...)
+	b := make([]byte, 1)
+	for {
+		if err := port.readByte(b, deadline); err != nil {
+			return response, err
+		}
+		response = append(response, b[0])
+		if len(response) >= len(expectPrefix)+len(terminator) && bytes.HasSuffix(response, terminator) {
+			return response, nil
+		}
+	}
+}
+
+// readDeadlineWithin returns the deadline of an operation that must
+// complete within timeout, or the read deadline of the port if earlier
+func (port *SerialPort) readDeadlineWithin(timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if d := loadDeadline(&port.readDeadline); !d.IsZero() && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+// readByte reads a single byte into b, retrying when the read timeout of
+// the port expires until deadline, that is used as the read deadline.
+func (port *SerialPort) readByte(b []byte, deadline time.Time) error {
+	for {
+		n, err := port.readWith(b, readOptions{deadline: deadline})
+		if serr, ok := err.(*SerialPortError); ok && serr.Timeout() && time.Now().Before(deadline) {
+			// read timeout reported as error (see SetReadTimeoutError)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			return nil
+		}
+		// read timeout expired, the deadline not yet
+	}
+}
+
+// DiscardUntilQuiet reads and discards the incoming data until the line
+// stays quiet for the given time, for example to skip the boot messages
+// of a device after the connection. If the line is still busy after max
+// an ERROR_TIMEOUT error is returned. The timeouts of the port are not
+// changed (see ReadWithTimeout).
+func (port *SerialPort) DiscardUntilQuiet(quiet time.Duration, max time.Duration) error {
+	if quiet <= 0 {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid quiet period"}
+	}
+	deadline := time.Now().Add(max)
+	buf := make([]byte, 256)
+	for {
+		timeout := quiet
+		if remaining := deadline.Sub(time.Now()); remaining < timeout {
+			if remaining <= 0 {
+				return &SerialPortError{code: ERROR_TIMEOUT}
+			}
+			timeout = remaining
+		}
+		n, err := port.ReadWithTimeout(buf, timeout)
+		if serr, ok := err.(*SerialPortError); ok && serr.Timeout() {
+			// read timeout reported as error (see SetReadTimeoutError)
+			n, err = 0, nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if timeout == quiet {
+				return nil
+			}
+			return &SerialPortError{code: ERROR_TIMEOUT}
+		}
+	}
+}
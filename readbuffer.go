@@ -0,0 +1,97 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "sync"
+
+// readBuffer holds the data fetched from the driver in advance by Read,
+// see SetReadBufferSize
+type readBuffer struct {
+	lock       sync.Mutex
+	buf        []byte
+	start, end int
+}
+
+// size returns the size of the buffer, zero if disabled
+func (b *readBuffer) size() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.buf)
+}
+
+// buffered returns the number of bytes waiting in the buffer
+func (b *readBuffer) buffered() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.end - b.start
+}
+
+// take moves the buffered data into p
+func (b *readBuffer) take(p []byte) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := copy(p, b.buf[b.start:b.end])
+	b.start += n
+	return n
+}
+
+// fill stores the data read from the driver into the buffer, it must be
+// called only when the buffer is empty
+func (b *readBuffer) fill(data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.start = 0
+	b.end = copy(b.buf, data)
+}
+
+// reset discards the buffered data
+func (b *readBuffer) reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.start, b.end = 0, 0
+}
+
+// bufferedPorts returns the indexes in ports of those with data in the
+// read buffer: Select reports them as ready without waiting, since the
+// driver has already delivered their data.
+func bufferedPorts(ports []*SerialPort) []int {
+	ready := []int{}
+	for i, port := range ports {
+		if port.readBuffer.buffered() > 0 {
+			ready = append(ready, i)
+		}
+	}
+	return ready
+}
+
+// SetReadBufferSize enables the read buffer of the port: when a Read asks
+// for less than size bytes, up to size bytes are fetched from the driver
+// and the exceeding data is kept for the following Reads. This saves a
+// system call for each Read when the data is read a few bytes at a time,
+// for example by a line parser. The Reads served from the buffer return
+// immediately, regardless of the timeouts. A size of zero disables the
+// buffer (the default). The data already buffered is preserved.
+//
+// The buffered data is counted by InputWaiting and by Select, and it's
+// discarded by ResetInputBuffer.
+func (port *SerialPort) SetReadBufferSize(size int) error {
+	if size < 0 {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid read buffer size"}
+	}
+	b := &port.readBuffer
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	pending := b.buf[b.start:b.end]
+	if size < len(pending) {
+		size = len(pending)
+	}
+	buf := make([]byte, size)
+	b.end = copy(buf, pending)
+	b.start = 0
+	b.buf = buf
+	return nil
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "context"
+import "sync"
+
+// RingReader continuously reads a serial port into a fixed size buffer,
+// overwriting the oldest data when the buffer is full. It's meant for
+// the applications interested only in the most recent data (for example
+// a telemetry display), that would otherwise slow down the reception.
+type RingReader struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock    sync.Mutex
+	buf     []byte
+	start   int // position of the oldest byte
+	n       int // number of bytes in the buffer
+	dropped uint64
+	err     error
+}
+
+// StartRingBufferReader starts reading the port in background into a ring
+// buffer of the given size. The port must not be read by others until
+// the RingReader is closed.
+func (port *SerialPort) StartRingBufferReader(size int) *RingReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RingReader{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		buf:    make([]byte, size),
+	}
+	go r.run(ctx, port)
+	return r
+}
+
+func (r *RingReader) run(ctx context.Context, port *SerialPort) {
+	defer close(r.done)
+	buf := make([]byte, 1024)
+	for {
+		n, err := port.ReadContext(ctx, buf)
+		if port.isReadTimeout(err) {
+			err = nil
+		}
+		r.lock.Lock()
+		if n > 0 {
+			r.store(buf[:n])
+		}
+		if err != nil && ctx.Err() == nil {
+			r.err = err
+		}
+		r.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// store appends data to the ring, must be called with the lock held
+func (r *RingReader) store(data []byte) {
+	size := len(r.buf)
+	if size == 0 {
+		r.dropped += uint64(len(data))
+		return
+	}
+	if len(data) > size {
+		r.dropped += uint64(len(data) - size)
+		data = data[len(data)-size:]
+	}
+	for _, b := range data {
+		if r.n == size {
+			r.start = (r.start + 1) % size
+			r.n--
+			r.dropped++
+		}
+		r.buf[(r.start+r.n)%size] = b
+		r.n++
+	}
+}
+
+// Latest returns a copy of the last n bytes received, or less if not
+// enough data has been received. The data is not removed from the
+// buffer.
+func (r *RingReader) Latest(n int) []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if n > r.n {
+		n = r.n
+	}
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = r.buf[(r.start+r.n-n+i)%len(r.buf)]
+	}
+	return res
+}
+
+// Dropped returns the number of bytes received and then overwritten by
+// newer data.
+func (r *RingReader) Dropped() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.dropped
+}
+
+// Err returns the error that stopped the reception, if any
+func (r *RingReader) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Close stops the reception and waits for the background goroutine to
+// exit. The port is not closed. Latest can still be called after Close.
+func (r *RingReader) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "time"
+
+// manualRS485 is the configuration of the RTS toggling done by Write,
+// see EnableManualRS485
+type manualRS485 struct {
+	rtsActiveLow bool
+	postTxDelay  time.Duration
+}
+
+// EnableManualRS485 makes Write drive the transmitter of a half-duplex
+// RS-485 transceiver with the RTS line: RTS is asserted before sending the
+// data, and deasserted when the data has left the UART (see Drain) and
+// postTxDelay has elapsed. Between the Writes RTS is kept deasserted, so
+// the transceiver is receiving. If rtsActiveLow is true the transmitter is
+// enabled by RTS low.
+//
+// This is the fallback for the drivers without RS-485 support, the timing
+// is subject to the latency of the driver and of the scheduler: the
+// transmitter may be released some time after the last bit, so a device
+// answering faster may be partially lost.
+func (port *SerialPort) EnableManualRS485(rtsActiveLow bool, postTxDelay time.Duration) error {
+	if err := port.SetRTS(rtsActiveLow); err != nil {
+		return err
+	}
+	port.rs485.Store(&manualRS485{rtsActiveLow: rtsActiveLow, postTxDelay: postTxDelay})
+	return nil
+}
+
+// DisableManualRS485 stops the RTS toggling enabled with EnableManualRS485,
+// RTS is left deasserted.
+func (port *SerialPort) DisableManualRS485() {
+	port.rs485.Store((*manualRS485)(nil))
+}
+
+// writeRS485 sends the data enabling the transmitter as configured with
+// EnableManualRS485
+func (port *SerialPort) writeRS485(p []byte, rs485 *manualRS485) (n int, err error) {
+	if err := port.SetRTS(!rs485.rtsActiveLow); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if rerr := port.SetRTS(rs485.rtsActiveLow); err == nil {
+			err = rerr
+		}
+	}()
+	n, err = port.write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := port.Drain(); err != nil {
+		return n, err
+	}
+	if rs485.postTxDelay > 0 {
+		time.Sleep(rs485.postTxDelay)
+	}
+	return n, nil
+}
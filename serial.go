@@ -6,14 +6,55 @@
 
 package serial
 
+import "log"
+import "os"
+import "runtime"
+
+// Logger is used to print diagnostic messages, like the warnings about
+// leaked ports. If set to nil the messages are discarded.
+var Logger = log.New(os.Stderr, "serial: ", log.LstdFlags)
+
+// If LeakWarnings is set to true, a warning is printed on the Logger when
+// a serial port is garbage collected without being closed. It affects only
+// the ports opened after it has been set.
+var LeakWarnings = false
+
+func logf(format string, v ...interface{}) {
+	if Logger != nil {
+		Logger.Printf(format, v...)
+	}
+}
+
+// watchLeak installs a finalizer that warns about a port that is never
+// closed, if LeakWarnings is enabled. forgetLeak removes it.
+func watchLeak(port *SerialPort) {
+	if LeakWarnings {
+		runtime.SetFinalizer(port, func(port *SerialPort) {
+			logf("port %s has been garbage collected without being closed", port.name)
+		})
+	}
+}
+
+func forgetLeak(port *SerialPort) {
+	runtime.SetFinalizer(port, nil)
+}
+
 // This structure describes a serial port configuration.
 type Mode struct {
-	BaudRate int      // The serial port bitrate (aka Baudrate)
-	DataBits int      // Size of the character (must be 5, 6, 7 or 8)
-	Parity   Parity   // Parity (see Parity type for more info)
-	StopBits StopBits // Stop bits (see StopBits type for more info)
-	Vmin     uint8    // Vmin (minimum characters to receive before returning)
-	Vtimeout uint8    // VTimeout (minimum time to wait before returning)
+	BaudRate    int         // The serial port bitrate (aka Baudrate)
+	DataBits    int         // Size of the character (must be 5, 6, 7 or 8)
+	Parity      Parity      // Parity (see Parity type for more info)
+	StopBits    StopBits    // Stop bits (see StopBits type for more info)
+	FlowControl FlowControl // Flow control (see FlowControl type for more info)
+	Vmin        uint8       // Vmin (minimum characters to receive before returning)
+	Vtimeout    uint8       // VTimeout (minimum time to wait before returning)
+	StripParity bool        // Clear the 8th bit of the received bytes (useful with 7 data bits and parity)
+
+	// TranslateCRLF translates the line endings like a terminal, for the
+	// line oriented text consoles: the received CRs are turned into NLs
+	// and the NLs sent are preceded by a CR (ICRNL and ONLCR on unix,
+	// emulated by Read and Write on windows). The default is raw data.
+	TranslateCRLF bool
 }
 
 type Parity int
@@ -30,14 +71,91 @@ type StopBits int
 
 const (
 	STOPBITS_ONE          StopBits = iota // 1 Stop bit
-	STOPBITS_ONEPOINTFIVE                 // 1.5 Stop bits
-	STOPBITS_TWO                          // 2 Stop bits
+	STOPBITS_ONEPOINTFIVE                 // 1.5 Stop bits (only with 5 data bits)
+	STOPBITS_TWO                          // 2 Stop bits (not with 5 data bits)
+)
+
+type FlowControl int
+
+const (
+	FLOWCONTROL_NONE    FlowControl = iota // No flow control (default)
+	FLOWCONTROL_RTSCTS                     // Hardware flow control using RTS/CTS
+	FLOWCONTROL_XONXOFF                    // Software flow control using XON/XOFF characters
+	FLOWCONTROL_DTRDSR                     // Hardware flow control using DTR/DSR (not available on linux)
+)
+
+// AccessMode specifies the directions in which a port is opened
+type AccessMode int
+
+const (
+	ACCESS_READ_WRITE AccessMode = iota // The port is opened for reading and writing (default)
+	ACCESS_READ_ONLY                    // The port is opened only for reading, Write fails
+	ACCESS_WRITE_ONLY                   // The port is opened only for writing, Read fails
+)
+
+// ErrorPolicy specifies what happens to a port when an operation finds
+// out that the device is no longer working (see SetDisconnectProbe)
+type ErrorPolicy int
+
+const (
+	ERRORPOLICY_FAULT     ErrorPolicy = iota // The port is marked as faulted until Recover is called (default)
+	ERRORPOLICY_CLOSE                        // The port is closed
+	ERRORPOLICY_PROPAGATE                    // The error is returned and the port is left untouched
+)
+
+// PortCapabilities describes the settings supported by a port, see
+// Capabilities. The empty lists and the zero values mean that the
+// information is not available.
+type PortCapabilities struct {
+	BaudRates    []int         // The standard baud rates supported, in increasing order
+	AnyBaudRate  bool          // Baud rates not in BaudRates are accepted too
+	MaxBaudRate  int           // The maximum baud rate
+	DataBits     []int         // The data bits supported
+	Parities     []Parity      // The parities supported
+	StopBits     []StopBits    // The stop bits supported
+	FlowControls []FlowControl // The flow controls supported
+	MaxRxQueue   int           // The maximum size of the receive queue of the driver
+	MaxTxQueue   int           // The maximum size of the transmit queue of the driver
+}
+
+// BreakMode specifies how a break condition received on the line is
+// handled (see SetBreakHandling)
+type BreakMode int
+
+const (
+	BREAKMODE_READ      BreakMode = iota // The break is read as a 0x00 byte, or as an ERROR_RX_PARITY error if SetParityErrorReporting is enabled (default)
+	BREAKMODE_IGNORE                     // The break is discarded
+	BREAKMODE_INTERRUPT                  // The input and output queues are flushed and SIGINT is sent to the foreground process group of the port, if it is a controlling terminal
+)
+
+// ControlChar identifies a special character of the termios settings
+// (see SetControlChar). The port is used in raw mode, so most of them are
+// meaningful only if the raw mode is changed by other means: the editing
+// characters only in canonical mode, the signal characters only if ISIG
+// is set.
+type ControlChar int
+
+const (
+	CONTROLCHAR_VINTR  ControlChar = iota // Interrupt signal (ISIG)
+	CONTROLCHAR_VQUIT                     // Quit signal (ISIG)
+	CONTROLCHAR_VSUSP                     // Suspend signal (ISIG)
+	CONTROLCHAR_VERASE                    // Erase a character (canonical mode)
+	CONTROLCHAR_VKILL                     // Erase the line (canonical mode)
+	CONTROLCHAR_VEOF                      // End of file (canonical mode)
+	CONTROLCHAR_VEOL                      // End of line (canonical mode)
+	CONTROLCHAR_VEOL2                     // Alternate end of line (canonical mode)
+	CONTROLCHAR_VSTART                    // XON character (XON/XOFF flow control)
+	CONTROLCHAR_VSTOP                     // XOFF character (XON/XOFF flow control)
 )
 
 // Platform independent error type for serial ports
 type SerialPortError struct {
 	err  string
 	code int
+	op   string // the failed operation (open, setmode, read, write, verify)
+	port string // the name of the port
+
+	cause error // the error wrapped by withPortName
 }
 
 const (
@@ -49,9 +167,32 @@ const (
 	ERROR_INVALID_PORT_DATA_BITS
 	ERROR_ENUMERATING_PORTS
 	ERROR_OTHER
+	ERROR_PORT_CLOSED
+	ERROR_PORT_RESET
+	ERROR_NOT_SUPPORTED
+	ERROR_TIMEOUT
+	ERROR_INVALID_PORT_PARITY
+	ERROR_INVALID_PORT_STOP_BITS
+	ERROR_INVALID_PORT_FLOW_CONTROL
+	ERROR_ACCESS_MODE
+	ERROR_CANCELED
+	ERROR_PORT_DISCONNECTED
+	ERROR_RX_OVERRUN
+	ERROR_NOT_A_SERIAL_PORT
+	ERROR_RX_PARITY
+	ERROR_MODE_MISMATCH
 )
 
+// Error returns the description of the error, preceded by the operation
+// and the port name if known (for example "open COM7: Serial port busy")
 func (e SerialPortError) Error() string {
+	if e.port != "" {
+		return e.op + " " + e.port + ": " + e.message()
+	}
+	return e.message()
+}
+
+func (e SerialPortError) message() string {
 	switch e.code {
 	case ERROR_PORT_BUSY:
 		return "Serial port busy"
@@ -67,6 +208,34 @@ func (e SerialPortError) Error() string {
 		return "Invalid port data bits"
 	case ERROR_ENUMERATING_PORTS:
 		return "Could not enumerate serial ports"
+	case ERROR_PORT_CLOSED:
+		return "Serial port closed"
+	case ERROR_PORT_RESET:
+		return "Serial port has been reset"
+	case ERROR_NOT_SUPPORTED:
+		return "Operation not supported on this platform"
+	case ERROR_TIMEOUT:
+		return "Serial port i/o timeout"
+	case ERROR_INVALID_PORT_PARITY:
+		return "Invalid port parity"
+	case ERROR_INVALID_PORT_STOP_BITS:
+		return "Invalid port stop bits"
+	case ERROR_INVALID_PORT_FLOW_CONTROL:
+		return "Invalid port flow control"
+	case ERROR_ACCESS_MODE:
+		return "Operation not allowed by the port access mode"
+	case ERROR_CANCELED:
+		return "Serial port i/o canceled"
+	case ERROR_PORT_DISCONNECTED:
+		return "Serial port disconnected"
+	case ERROR_RX_OVERRUN:
+		return "Received data lost (overrun)"
+	case ERROR_NOT_A_SERIAL_PORT:
+		return "The device is not a serial port"
+	case ERROR_RX_PARITY:
+		return "Received data with parity or framing errors"
+	case ERROR_MODE_MISMATCH:
+		return "The driver applied settings different from the requested ones"
 	}
 	return e.err
 }
@@ -75,4 +244,164 @@ func (e SerialPortError) Code() int {
 	return e.code
 }
 
+// Op returns the operation that failed: "open" (OpenPort, Open and
+// OpenPTY), "setmode" (SetMode), "read" (Read and the functions built on
+// it), "write" (Write and the functions built on it) or "verify" (the
+// check of OpenAndVerify). It's an empty string if unknown.
+func (e SerialPortError) Op() string {
+	return e.op
+}
+
+// PortName returns the name of the port that failed, or an empty string
+// if unknown
+func (e SerialPortError) PortName() string {
+	return e.port
+}
+
+// Unwrap returns the error reported by the operating system, if the
+// SerialPortError wraps one with the ERROR_OTHER code, or nil
+func (e SerialPortError) Unwrap() error {
+	return e.cause
+}
+
+// withPortName adds the operation and the port name to a SerialPortError,
+// unless it already carries them. The other errors are wrapped in a
+// SerialPortError with the ERROR_OTHER code.
+func withPortName(op, portName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	serr, ok := err.(*SerialPortError)
+	if !ok {
+		serr = &SerialPortError{code: ERROR_OTHER, err: err.Error(), cause: err}
+	}
+	if serr.port == "" {
+		serr.op = op
+		serr.port = portName
+	}
+	return serr
+}
+
+var (
+	// ErrPortClosed matches, with errors.Is, the errors returned by the
+	// operations on a closed port, including the Reads and Writes that
+	// were waiting when the port has been closed.
+	ErrPortClosed error = &SerialPortError{code: ERROR_PORT_CLOSED}
+
+	// ErrPortDisconnected matches the errors returned when the device has
+	// been unplugged or stopped responding (see SetDisconnectProbe).
+	ErrPortDisconnected error = &SerialPortError{code: ERROR_PORT_DISCONNECTED}
+
+	// ErrTimeout matches the errors returned when a deadline expires, or
+	// a read timeout if SetReadTimeoutError is enabled.
+	ErrTimeout error = &SerialPortError{code: ERROR_TIMEOUT}
+
+	// ErrNotASerialPort matches the error returned by OpenPort when the
+	// device opened is not a serial port, like a mistyped name opening
+	// CON on windows.
+	ErrNotASerialPort error = &SerialPortError{code: ERROR_NOT_A_SERIAL_PORT}
+)
+
+// Is reports if target is a SerialPortError with the same code, so that
+// errors.Is(err, ErrPortClosed) works regardless of the operation and
+// port name carried by err.
+func (e SerialPortError) Is(target error) bool {
+	t, ok := target.(*SerialPortError)
+	return ok && e.code != ERROR_OTHER && t.code == e.code
+}
+
+// Timeout returns true if the error is caused by an expired deadline
+// (it makes SerialPortError implement the net.Error interface).
+func (e SerialPortError) Timeout() bool {
+	return e.code == ERROR_TIMEOUT
+}
+
+// Temporary returns true if the operation may succeed if retried.
+func (e SerialPortError) Temporary() bool {
+	return e.code == ERROR_TIMEOUT
+}
+
+// isolateInvalidSetting is used when the driver rejects a mode as a whole:
+// it applies each setting of the mode alone (the others are left to their
+// default) with the given function, and returns the error corresponding to
+// the first setting that is rejected. If no setting is rejected alone, nil
+// is returned.
+func isolateInvalidSetting(mode *Mode, apply func(*Mode) error) error {
+	checks := []struct {
+		code int
+		set  func(*Mode)
+	}{
+		{ERROR_INVALID_PORT_SPEED, func(m *Mode) { m.BaudRate = mode.BaudRate }},
+		{ERROR_INVALID_PORT_DATA_BITS, func(m *Mode) { m.DataBits = mode.DataBits }},
+		{ERROR_INVALID_PORT_PARITY, func(m *Mode) { m.Parity = mode.Parity }},
+		{ERROR_INVALID_PORT_STOP_BITS, func(m *Mode) { m.StopBits = mode.StopBits }},
+		{ERROR_INVALID_PORT_FLOW_CONTROL, func(m *Mode) { m.FlowControl = mode.FlowControl }},
+	}
+	for _, check := range checks {
+		m := &Mode{}
+		check.set(m)
+		if apply(m) != nil {
+			return &SerialPortError{code: check.code}
+		}
+	}
+	return nil
+}
+
+// Validate checks that the settings of the mode are valid and supported
+// on this platform, without touching any device, so that a configuration
+// can be rejected before opening a port. The invalid settings are
+// reported with the corresponding error code (like
+// ERROR_INVALID_PORT_DATA_BITS), the settings that are valid but not
+// available on this platform (like the mark parity on darwin) with
+// ERROR_NOT_SUPPORTED. SetMode and OpenPort perform the same checks. The
+// driver may still reject a valid mode, for example a baud rate that the
+// hardware can't generate.
+func (m *Mode) Validate() error {
+	if !validBaudRate(m.BaudRate) {
+		return &SerialPortError{code: ERROR_INVALID_PORT_SPEED}
+	}
+	if m.DataBits != 0 && (m.DataBits < 5 || m.DataBits > 8) {
+		return &SerialPortError{code: ERROR_INVALID_PORT_DATA_BITS}
+	}
+	switch m.Parity {
+	case PARITY_NONE, PARITY_ODD, PARITY_EVEN:
+	case PARITY_MARK, PARITY_SPACE:
+		if !markSpaceParity {
+			return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+		}
+	default:
+		return &SerialPortError{code: ERROR_INVALID_PORT_PARITY}
+	}
+	switch m.StopBits {
+	case STOPBITS_ONE, STOPBITS_ONEPOINTFIVE, STOPBITS_TWO:
+	default:
+		return &SerialPortError{code: ERROR_INVALID_PORT_STOP_BITS}
+	}
+	if err := checkStopBits(m); err != nil {
+		return err
+	}
+	switch m.FlowControl {
+	case FLOWCONTROL_NONE, FLOWCONTROL_RTSCTS, FLOWCONTROL_XONXOFF:
+	case FLOWCONTROL_DTRDSR:
+		if !dtrDsrFlowControl {
+			return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+		}
+	default:
+		return &SerialPortError{code: ERROR_INVALID_PORT_FLOW_CONTROL}
+	}
+	return nil
+}
+
+// checkStopBits rejects the combinations of data and stop bits that the
+// UARTs can't generate: 1.5 stop bits are available only with 5 data bits,
+// that in turn can't be used with 2 stop bits (the UART would send 1.5
+// stop bits instead).
+func checkStopBits(mode *Mode) error {
+	fiveBits := mode.DataBits == 5
+	if (mode.StopBits == STOPBITS_ONEPOINTFIVE && !fiveBits) || (mode.StopBits == STOPBITS_TWO && fiveBits) {
+		return &SerialPortError{code: ERROR_INVALID_PORT_STOP_BITS}
+	}
+	return nil
+}
+
 // vi:ts=2
@@ -6,10 +6,14 @@
 
 package serial
 
+import "bytes"
+import "context"
 import "syscall"
+import "time"
+import "unsafe"
 
 const devFolder = "/dev"
-const regexFilter = "^(cu|tty)\\..*"
+const regexFilter = "^cu\\..*"
 
 // termios manipulation functions
 
@@ -46,9 +50,133 @@ var databitsMap = map[int]int{
 const tc_CMSPAR int = 0 // may be CMSPAR or PAREXT
 const tc_IUCLC int = 0
 
+// termiosSpeed returns the speed (as a Bxxx constant) set in the termios
+func termiosSpeed(settings *syscall.Termios) int {
+	return int(settings.Ospeed)
+}
+
+// isRFCOMM returns true if the port is a bluetooth RFCOMM device, on
+// darwin bluetooth ports behave like the other serial ports.
+func isRFCOMM(portName string) bool {
+	return false
+}
+
 // syscall wrappers
 
 //sys ioctl(fd int, req uint64, data uintptr) (err error)
 
 const ioctl_tcgetattr = syscall.TIOCGETA
 const ioctl_tcsetattr = syscall.TIOCSETA
+const ioctl_tcdrain = syscall.TIOCDRAIN
+const ioctl_tcdrainArg = 0
+
+const ioctl_inq = 0x4004667f // FIONREAD
+
+// flushInput discards the data received but not read (tcflush TCIFLUSH)
+func (port *SerialPort) flushInput() error {
+	const FREAD = 0x0001
+	which := int32(FREAD)
+	return ioctl(port.handle, syscall.TIOCFLUSH, uintptr(unsafe.Pointer(&which)))
+}
+
+// sysPoll waits for the events in fds, a timeout < 0 waits forever
+func sysPoll(fds []pollFd, timeout time.Duration) (int, error) {
+	ms := -1
+	if timeout >= 0 {
+		ms = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), uintptr(ms))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+// GetLineDiscipline is not supported on darwin.
+func (port *SerialPort) GetLineDiscipline() (int, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetLineDiscipline is not supported on darwin.
+func (port *SerialPort) SetLineDiscipline(ldisc int) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// CapturePPS is not supported on darwin.
+func (port *SerialPort) CapturePPS(ctx context.Context) (<-chan time.Time, error) {
+	return nil, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// EnableOverrunDetection is not supported on darwin.
+func (port *SerialPort) EnableOverrunDetection(enable bool) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+func (port *SerialPort) checkOverrun() bool {
+	return false
+}
+
+// WaitForRing is not supported on darwin.
+func (port *SerialPort) WaitForRing(ctx context.Context) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// ptsName grants and unlocks the slave side of the pseudo-terminal whose
+// master is fd and returns its name
+func ptsName(fd int) (string, error) {
+	if err := ioctl(fd, syscall.TIOCPTYGRANT, 0); err != nil {
+		return "", err
+	}
+	if err := ioctl(fd, syscall.TIOCPTYUNLK, 0); err != nil {
+		return "", err
+	}
+	var name [128]byte
+	if err := ioctl(fd, syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); err != nil {
+		return "", err
+	}
+	if i := bytes.IndexByte(name[:], 0); i != -1 {
+		return string(name[:i]), nil
+	}
+	return string(name[:]), nil
+}
+
+// modemStatusFallback is not supported on darwin, the drivers always
+// implement TIOCMGET.
+func (port *SerialPort) modemStatusFallback(sig Signal) (bool, error) {
+	return false, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// BufferSizes is not supported on darwin.
+func (port *SerialPort) BufferSizes() (rx, tx int, err error) {
+	return 0, 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// MaxBaudRate is not supported on darwin.
+func (port *SerialPort) MaxBaudRate() (int, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetLatencyTimer is not supported on darwin.
+func (port *SerialPort) SetLatencyTimer(d time.Duration) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// ProbeBaudError is not supported on darwin.
+func (port *SerialPort) ProbeBaudError(duration time.Duration) (float64, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetReceiveTriggerLevel is not supported on darwin.
+func (port *SerialPort) SetReceiveTriggerLevel(n int) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// ReceiveTriggerLevel is not supported on darwin.
+func (port *SerialPort) ReceiveTriggerLevel() (int, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// InterruptCounts is not supported on darwin.
+func (port *SerialPort) InterruptCounts() (ICounts, error) {
+	return ICounts{}, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
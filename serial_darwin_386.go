@@ -6,6 +6,9 @@
 
 package serial
 
+const tc_CRTSCTS uint32 = 0x00030000 // CCTS_OFLOW | CRTS_IFLOW
+const tc_CDTRDSR uint32 = 0x000C0000 // CDTR_IFLOW | CDSR_OFLOW
+
 func termiosMask(data int) uint32 {
 	return uint32(data)
 }
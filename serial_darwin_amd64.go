@@ -6,6 +6,9 @@
 
 package serial
 
+const tc_CRTSCTS uint64 = 0x00030000 // CCTS_OFLOW | CRTS_IFLOW
+const tc_CDTRDSR uint64 = 0x000C0000 // CDTR_IFLOW | CDSR_OFLOW
+
 // termios manipulation functions
 
 func termiosMask(data int) uint64 {
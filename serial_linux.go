@@ -6,7 +6,16 @@
 
 package serial
 
+import "context"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync/atomic"
 import "syscall"
+import "time"
+import "unsafe"
 
 const devFolder = "/dev"
 const regexFilter = "(ttyS|ttyUSB|ttyACM|ttyAMA|rfcomm|ttyO)[0-9]{1,3}"
@@ -55,13 +64,27 @@ var databitsMap = map[int]int{
 	8: syscall.CS8,
 }
 
-const tc_CMSPAR int = 0 // may be CMSPAR or PAREXT
+const tc_CMSPAR int = 0x40000000 // CMSPAR (mark/space parity), not defined in syscall
 const tc_IUCLC = syscall.IUCLC
+const tc_CRTSCTS uint32 = 0x80000000
+const tc_CDTRDSR uint32 = 0 // DTR/DSR flow control is not available
 
 func termiosMask(data int) uint32 {
 	return uint32(data)
 }
 
+const tc_CBAUD = 0x0000100f // CBAUD | CBAUDEX
+
+// termiosSpeed returns the speed (as a Bxxx constant) set in the termios
+func termiosSpeed(settings *syscall.Termios) int {
+	return int(settings.Cflag & tc_CBAUD)
+}
+
+// isRFCOMM returns true if the port is a bluetooth RFCOMM (SPP) device
+func isRFCOMM(portName string) bool {
+	return strings.HasPrefix(filepath.Base(portName), "rfcomm")
+}
+
 // syscall wrappers
 
 //sys ioctl(fd int, req uint64, data uintptr) (err error)
@@ -69,3 +92,368 @@ func termiosMask(data int) uint32 {
 const ioctl_tcgetattr = syscall.TCGETS
 const ioctl_tcsetattr = syscall.TCSETS
 const ioctl_tiocmdtr = syscall.TIOCM_DTR
+
+// tcdrain is TCSBRK with a non-zero argument
+const ioctl_tcdrain = 0x5409 // TCSBRK
+const ioctl_tcdrainArg = 1
+
+const ioctl_inq = syscall.TIOCINQ
+
+// flushInput discards the data received but not read (tcflush TCIFLUSH)
+func (port *SerialPort) flushInput() error {
+	const TCFLSH = 0x540B
+	return ioctl(port.handle, TCFLSH, syscall.TCIFLUSH)
+}
+
+// sysPoll waits for the events in fds, a timeout < 0 waits forever. ppoll
+// is used since poll is missing on some architectures (like arm64).
+func sysPoll(fds []pollFd, timeout time.Duration) (int, error) {
+	var ts *syscall.Timespec
+	if timeout >= 0 {
+		t := syscall.NsecToTimespec(timeout.Nanoseconds())
+		ts = &t
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), uintptr(unsafe.Pointer(ts)), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+// GetLineDiscipline returns the line discipline attached to the serial
+// port (0 is N_TTY, the default line discipline).
+func (port *SerialPort) GetLineDiscipline() (int, error) {
+	var ldisc int32
+	err := ioctl(port.handle, syscall.TIOCGETD, uintptr(unsafe.Pointer(&ldisc)))
+	return int(ldisc), err
+}
+
+// SetLineDiscipline attaches the line discipline ldisc to the serial port.
+func (port *SerialPort) SetLineDiscipline(ldisc int) error {
+	l := int32(ldisc)
+	return ioctl(port.handle, syscall.TIOCSETD, uintptr(unsafe.Pointer(&l)))
+}
+
+// CapturePPS timestamps every transition of the DCD (Carrier Detect) line,
+// as produced by the pulse-per-second output of many GPS receivers. The
+// timestamps are sent on the returned channel, that is closed when ctx
+// is canceled or the port is closed. Since the kernel wait can't be
+// interrupted, the channel is closed only after the next DCD transition:
+// the wait uses a duplicate of the file descriptor, so the device stays
+// open until then even if the port is closed.
+func (port *SerialPort) CapturePPS(ctx context.Context) (<-chan time.Time, error) {
+	fd, err := port.dupHandle()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan time.Time, 16)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		for ctx.Err() == nil && port.isOpen() {
+			if err := ioctl(fd, syscall.TIOCMIWAIT, syscall.TIOCM_CD); err != nil {
+				return
+			}
+			ts := time.Now()
+			select {
+			case events <- ts:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// WaitForRing waits for a ring signal on the RI (Ring Indicator) line.
+// It returns ctx.Err() if ctx is canceled before the ring arrives, but
+// since the kernel wait can't be interrupted, the wait goes on in
+// background until the next transition of RI: meanwhile it keeps a
+// duplicate of the file descriptor, so the device stays open even if the
+// port is closed. Call it in a loop to count the rings.
+func (port *SerialPort) WaitForRing(ctx context.Context) error {
+	fd, err := port.dupHandle()
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer syscall.Close(fd)
+		done <- ioctl(fd, syscall.TIOCMIWAIT, syscall.TIOCM_RNG)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ptsName unlocks the slave side of the pseudo-terminal whose master is
+// fd and returns its name
+func ptsName(fd int) (string, error) {
+	var n uint32
+	if err := ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		return "", err
+	}
+	var unlock int32
+	if err := ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		return "", err
+	}
+	return "/dev/pts/" + strconv.Itoa(int(n)), nil
+}
+
+// serialStruct is the serial_struct used by TIOCGSERIAL and TIOCSSERIAL
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	IRQ           int32
+	Flags         int32
+	XmitFifoSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IOType        byte
+	ReservedChar  byte
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IOMemBase     uintptr
+	IOMemRegShift uint16
+	PortHigh      uint32
+	IOMapBase     uintptr
+}
+
+func (port *SerialPort) getSerialStruct() (*serialStruct, error) {
+	ss := &serialStruct{}
+	if err := ioctl(port.handle, syscall.TIOCGSERIAL, uintptr(unsafe.Pointer(ss))); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// BufferSizes returns the size of the receive and transmit buffers of the
+// port. On linux there is no way to query the buffers of the tty layer,
+// so the size of the hardware FIFO of the UART (the xmit_fifo_size
+// reported by TIOCGSERIAL) is returned for both. Many USB adapters don't
+// report it, in that case an ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) BufferSizes() (rx, tx int, err error) {
+	ss, err := port.getSerialStruct()
+	if err != nil || ss.XmitFifoSize <= 0 {
+		return 0, 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return int(ss.XmitFifoSize), int(ss.XmitFifoSize), nil
+}
+
+// MaxBaudRate returns the maximum baud rate of the UART, that is the
+// baud_base reported by TIOCGSERIAL (the rate obtained with a divisor of
+// 1). Many USB adapters don't report it, in that case an
+// ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) MaxBaudRate() (int, error) {
+	ss, err := port.getSerialStruct()
+	if err != nil || ss.BaudBase <= 0 {
+		return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return int(ss.BaudBase), nil
+}
+
+// SetLatencyTimer sets the latency timer of FTDI adapters, that is the
+// time the adapter waits before sending to the host a partially filled
+// USB packet. The default of 16ms slows down the protocols exchanging
+// small packets, 1ms is the minimum. The timer is set through the
+// latency_timer attribute in sysfs, that is usually writable only by
+// root. For the other adapters an ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) SetLatencyTimer(d time.Duration) error {
+	ms := int(d / time.Millisecond)
+	if ms < 1 || ms > 255 {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid latency timer"}
+	}
+	return port.writeSysfsAttribute("device/latency_timer", ms)
+}
+
+// sysfsAttribute returns the path of the given attribute of the tty
+// device in sysfs, or an ERROR_NOT_SUPPORTED error if the attribute
+// doesn't exist.
+func (port *SerialPort) sysfsAttribute(attr string) (string, error) {
+	name, err := filepath.EvalSymlinks(port.name)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join("/sys/class/tty", filepath.Base(name), attr)
+	if _, err := os.Stat(path); err != nil {
+		return "", &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return path, nil
+}
+
+func (port *SerialPort) writeSysfsAttribute(attr string, value int) error {
+	path, err := port.sysfsAttribute(attr)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(value)), 0644)
+}
+
+// SetReceiveTriggerLevel sets the number of bytes that the receive FIFO
+// of the UART must hold before raising an interrupt: a low level reduces
+// the latency, a high level reduces the CPU load at high speed. The
+// driver rounds the level to one supported by the UART. The level is set
+// through the rx_trig_bytes attribute in sysfs, provided by the 8250
+// family of UARTs and usually writable only by root. For the other
+// devices an ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) SetReceiveTriggerLevel(n int) error {
+	return port.writeSysfsAttribute("rx_trig_bytes", n)
+}
+
+// ReceiveTriggerLevel returns the receive trigger level of the UART, see
+// SetReceiveTriggerLevel.
+func (port *SerialPort) ReceiveTriggerLevel() (int, error) {
+	path, err := port.sysfsAttribute("rx_trig_bytes")
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// modemStatusAttributes are the names of the sysfs attributes reporting
+// the level of the input lines
+var modemStatusAttributes = map[Signal]string{
+	SIGNAL_CTS: "cts",
+	SIGNAL_DSR: "dsr",
+	SIGNAL_RI:  "ri",
+	SIGNAL_DCD: "dcd",
+}
+
+// modemStatusFallback reads the level of an input line from sysfs, for
+// the UART drivers that don't implement TIOCMGET. The mainline drivers
+// don't export the lines, but the kernels of some embedded boards (like
+// the Allwinner ones) provide an attribute for each line (cts, dsr, ri
+// and dcd) in the tty device folder, containing 0 or 1.
+func (port *SerialPort) modemStatusFallback(sig Signal) (bool, error) {
+	attr, ok := modemStatusAttributes[sig]
+	if !ok {
+		return false, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	path, err := port.sysfsAttribute(attr)
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, err
+	}
+	return level != 0, nil
+}
+
+// serialICounter is the serial_icounter_struct used by TIOCGICOUNT
+type serialICounter struct {
+	CTS, DSR, RNG, DCD int32
+	RX, TX             int32
+	Frame, Overrun     int32
+	Parity, Brk        int32
+	BufOverrun         int32
+	Reserved           [9]int32
+}
+
+func (port *SerialPort) getICount() (*serialICounter, error) {
+	count := &serialICounter{}
+	if err := ioctl(port.handle, syscall.TIOCGICOUNT, uintptr(unsafe.Pointer(count))); err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// ProbeBaudError reads (and discards) the data received for the given
+// duration and returns the fraction of the received bytes that had a
+// framing or parity error: a high rate means that the baud rate doesn't
+// match the one of the device. The error counters are read with
+// TIOCGICOUNT, if the driver doesn't provide them an ERROR_NOT_SUPPORTED
+// error is returned. If no data is received the rate is 0.
+func (port *SerialPort) ProbeBaudError(duration time.Duration) (float64, error) {
+	before, err := port.getICount()
+	if err != nil {
+		return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(duration)
+	for remaining := duration; remaining > 0; remaining = deadline.Sub(time.Now()) {
+		if _, err := port.discard(buf, remaining); err != nil {
+			return 0, err
+		}
+	}
+	after, err := port.getICount()
+	if err != nil {
+		return 0, err
+	}
+	received := after.RX - before.RX
+	if received <= 0 {
+		return 0, nil
+	}
+	bad := (after.Frame - before.Frame) + (after.Parity - before.Parity)
+	return float64(bad) / float64(received), nil
+}
+
+// EnableOverrunDetection makes Read report the data lost because of an
+// overrun of the UART FIFO or of the driver buffer (see Read). The
+// overruns are detected through the counters of TIOCGICOUNT, if the
+// driver doesn't provide them an ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) EnableOverrunDetection(enable bool) error {
+	if !enable {
+		atomic.StoreInt32(&port.overrunDetection, 0)
+		return nil
+	}
+	count, err := port.getICount()
+	if err != nil {
+		return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	atomic.StoreInt64(&port.overruns, int64(count.Overrun)+int64(count.BufOverrun))
+	atomic.StoreInt32(&port.overrunDetection, 1)
+	return nil
+}
+
+// checkOverrun returns true if an overrun happened since the last check
+func (port *SerialPort) checkOverrun() bool {
+	count, err := port.getICount()
+	if err != nil {
+		return false
+	}
+	overruns := int64(count.Overrun) + int64(count.BufOverrun)
+	return atomic.SwapInt64(&port.overruns, overruns) != overruns
+}
+
+// InterruptCounts returns the counters of the events kept by the driver
+// (TIOCGICOUNT). Not all the drivers provide them, in that case an
+// ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) InterruptCounts() (ICounts, error) {
+	count, err := port.getICount()
+	if err != nil {
+		return ICounts{}, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return count.iCounts(), nil
+}
+
+// iCounts converts the counters returned by the driver into ICounts
+func (c *serialICounter) iCounts() ICounts {
+	return ICounts{
+		RX:            int(c.RX),
+		TX:            int(c.TX),
+		Frame:         int(c.Frame),
+		Overrun:       int(c.Overrun),
+		Parity:        int(c.Parity),
+		Break:         int(c.Brk),
+		BufferOverrun: int(c.BufOverrun),
+		CTS:           int(c.CTS),
+		DSR:           int(c.DSR),
+		RI:            int(c.RNG),
+		DCD:           int(c.DCD),
+	}
+}
@@ -0,0 +1,43 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "errors"
+import "testing"
+import "unsafe"
+
+func TestICounts(t *testing.T) {
+	// serial_icounter_struct is made of 20 ints
+	if size := unsafe.Sizeof(serialICounter{}); size != 20*4 {
+		t.Fatalf("serialICounter is %d bytes, want %d", size, 20*4)
+	}
+	// the counters as stored by the kernel: cts, dsr, rng, dcd, rx, tx,
+	// frame, overrun, parity, brk, buf_overrun
+	var raw [20]int32
+	for i := range raw {
+		raw[i] = int32(i + 1)
+	}
+	got := (*serialICounter)(unsafe.Pointer(&raw)).iCounts()
+	want := ICounts{
+		CTS: 1, DSR: 2, RI: 3, DCD: 4,
+		RX: 5, TX: 6,
+		Frame: 7, Overrun: 8, Parity: 9, Break: 10, BufferOverrun: 11,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestInterruptCountsNotSupported(t *testing.T) {
+	// the pseudo-terminals don't keep the counters
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	defer slave.Close()
+	if _, err := slave.InterruptCounts(); !errors.Is(err, &SerialPortError{code: ERROR_NOT_SUPPORTED}) {
+		t.Errorf("InterruptCounts returned %v, want ERROR_NOT_SUPPORTED", err)
+	}
+}
@@ -0,0 +1,166 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "errors"
+import "os"
+import "runtime"
+import "strings"
+import "testing"
+
+// openTestPort opens the serial port named by the SERIAL_TEST_PORT
+// environment variable: the tests that need a real port, like the ones
+// driving the modem lines, are skipped if it's not set.
+func openTestPort(t *testing.T, mode *Mode) *SerialPort {
+	name := os.Getenv("SERIAL_TEST_PORT")
+	if name == "" {
+		t.Skip("SERIAL_TEST_PORT not set")
+	}
+	port, err := OpenPort(name, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestSetModeKeepsDTR(t *testing.T) {
+	port := openTestPort(t, &Mode{BaudRate: 9600})
+	defer port.Close()
+	if err := port.SetDTR(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := port.SetMode(&Mode{BaudRate: 19200}); err != nil {
+		t.Fatal(err)
+	}
+	if dtr, _ := port.ModemControlState(); dtr {
+		t.Error("DTR raised by SetMode")
+	}
+}
+
+func TestErrorsIs(t *testing.T) {
+	tests := []struct {
+		err    error
+		target error
+		want   bool
+	}{
+		{withPortName("open", "CON", &SerialPortError{code: ERROR_NOT_A_SERIAL_PORT}), ErrNotASerialPort, true},
+		{withPortName("read", "COM1", &SerialPortError{code: ERROR_PORT_CLOSED}), ErrPortClosed, true},
+		{&SerialPortError{code: ERROR_PORT_DISCONNECTED}, ErrPortDisconnected, true},
+		{&SerialPortError{code: ERROR_TIMEOUT}, ErrTimeout, true},
+		{&SerialPortError{code: ERROR_INVALID_SERIAL_PORT}, ErrNotASerialPort, false},
+		{errors.New("not a serial port"), ErrNotASerialPort, false},
+	}
+	for _, test := range tests {
+		if got := errors.Is(test.err, test.target); got != test.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", test.err, test.target, got, test.want)
+		}
+	}
+}
+
+func TestStopBits(t *testing.T) {
+	tests := []struct {
+		dataBits int
+		stopBits StopBits
+		valid    bool
+	}{
+		{5, STOPBITS_ONE, true},
+		{5, STOPBITS_ONEPOINTFIVE, true}, // Baudot
+		{5, STOPBITS_TWO, false},
+		{6, STOPBITS_ONEPOINTFIVE, false},
+		{6, STOPBITS_TWO, true},
+		{7, STOPBITS_TWO, true},
+		{8, STOPBITS_ONEPOINTFIVE, false},
+		{0, STOPBITS_ONEPOINTFIVE, false}, // 8 data bits by default
+		{0, STOPBITS_TWO, true},
+	}
+	for _, test := range tests {
+		mode := &Mode{BaudRate: 9600, DataBits: test.dataBits, StopBits: test.stopBits}
+		err := mode.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%d data bits, stop bits %d: %v", test.dataBits, test.stopBits, err)
+		}
+		if !test.valid && (err == nil || err.(*SerialPortError).Code() != ERROR_INVALID_PORT_STOP_BITS) {
+			t.Errorf("%d data bits, stop bits %d: got %v, want ERROR_INVALID_PORT_STOP_BITS", test.dataBits, test.stopBits, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// the codes of the settings that may be missing on this platform
+	markSpace, dtrDsr := -1, -1
+	if !markSpaceParity {
+		markSpace = ERROR_NOT_SUPPORTED
+	}
+	if !dtrDsrFlowControl {
+		dtrDsr = ERROR_NOT_SUPPORTED
+	}
+	tests := []struct {
+		mode Mode
+		code int // -1 if valid
+	}{
+		{Mode{}, -1},
+		{Mode{BaudRate: 115200, DataBits: 7, Parity: PARITY_EVEN, StopBits: STOPBITS_TWO, FlowControl: FLOWCONTROL_RTSCTS}, -1},
+		{Mode{BaudRate: 9600, FlowControl: FLOWCONTROL_XONXOFF}, -1},
+		{Mode{BaudRate: -1}, ERROR_INVALID_PORT_SPEED},
+		{Mode{BaudRate: 9600, DataBits: 4}, ERROR_INVALID_PORT_DATA_BITS},
+		{Mode{BaudRate: 9600, DataBits: 9}, ERROR_INVALID_PORT_DATA_BITS},
+		{Mode{BaudRate: 9600, Parity: Parity(42)}, ERROR_INVALID_PORT_PARITY},
+		{Mode{BaudRate: 9600, DataBits: 8, Parity: PARITY_MARK}, markSpace},
+		{Mode{BaudRate: 9600, DataBits: 7, Parity: PARITY_SPACE}, markSpace},
+		{Mode{BaudRate: 9600, StopBits: StopBits(42)}, ERROR_INVALID_PORT_STOP_BITS},
+		{Mode{BaudRate: 9600, DataBits: 8, StopBits: STOPBITS_ONEPOINTFIVE}, ERROR_INVALID_PORT_STOP_BITS},
+		{Mode{BaudRate: 9600, FlowControl: FlowControl(42)}, ERROR_INVALID_PORT_FLOW_CONTROL},
+		{Mode{BaudRate: 9600, FlowControl: FLOWCONTROL_DTRDSR}, dtrDsr},
+	}
+	for _, test := range tests {
+		code := -1
+		if err := test.mode.Validate(); err != nil {
+			code = err.(*SerialPortError).Code()
+		}
+		if code != test.code {
+			t.Errorf("Validate of %+v returned code %d, want %d", test.mode, code, test.code)
+		}
+	}
+}
+
+func TestGetPortsList(t *testing.T) {
+	if testing.Short() {
+		t.Skip("opens every serial port of the system")
+	}
+	ports, err := GetPortsList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range ports {
+		switch runtime.GOOS {
+		case "windows":
+			// bare names like COM3, without the device namespace prefix
+			if strings.Contains(name, `\`) {
+				t.Errorf("%q is not a port name", name)
+			}
+		case "darwin":
+			if !strings.HasPrefix(name, "/dev/cu.") {
+				t.Errorf("%q is not a callout device", name)
+			}
+		default:
+			if !strings.HasPrefix(name, "/dev/") {
+				t.Errorf("%q is not a device path", name)
+			}
+		}
+		// the names must be accepted as-is by OpenPort
+		port, err := OpenPort(name, &Mode{})
+		if err == nil {
+			port.Close()
+			continue
+		}
+		if serr, ok := err.(*SerialPortError); ok && (serr.Code() == ERROR_PORT_BUSY || serr.Code() == ERROR_PERMISSION_DENIED) {
+			// in use or not accessible, but found
+			continue
+		}
+		t.Errorf("OpenPort(%q) returned %v", name, err)
+	}
+}
@@ -8,101 +8,1241 @@
 
 package serial
 
+import "bytes"
+import "context"
+import "errors"
 import "io/ioutil"
 import "regexp"
+import "sort"
 import "strings"
+import "sync"
+import "sync/atomic"
 import "syscall"
+import "time"
 import "unsafe"
 
 // Opaque type that implements SerialPort interface for linux
 type SerialPort struct {
+	// overruns counted by the driver at the last check of the overrun
+	// detection. It's accessed atomically and comes first to be 64-bit
+	// aligned on the 32-bit platforms.
+	overruns int64
+
 	handle int
+	name   string
+	mode   Mode
+	access AccessMode
+
+	// closeLock is held (read) by Read while it waits for data and
+	// (write) by Close and Reset while the handle is replaced.
+	closeLock sync.RWMutex
+	// closeSignal is a pipe used to wake up a blocked Read, the reason
+	// is stored in interruptCode (0 if the deadlines have been changed).
+	closeSignal   [2]int
+	interruptCode int32
+	opened        bool
+
+	// last levels set on the DTR, RTS and break lines
+	dtr, rts, brk bool
+
+	latency        latencyRecorder
+	latencyEnabled int32
+	tracer         atomic.Value
+
+	readTimeout   time.Duration
+	writeTimeout  time.Duration // used if timeouts is nil
+	timeouts      *Timeouts
+	readDeadline  atomic.Value // time.Time
+	writeDeadline atomic.Value // time.Time
+
+	// timeoutsLock serializes the changes of the timeouts
+	timeoutsLock sync.Mutex
+
+	readBuffer readBuffer
+
+	// the settings below can be changed while a Read or Write is in
+	// progress, they are accessed atomically
+	probeSettings atomic.Value // disconnectProbe
+	faulted       int32
+	errorPolicy   int32 // ErrorPolicy
+	maxReadChunk  int32
+
+	rs485            atomic.Value // *manualRS485
+	readTimeoutError int32
+	minReadBytes     int32
+	strictMode       int32
+
+	fanout fanout
+
+	// parityMarks is set when the parity error marks are enabled, it's
+	// accessed atomically since Read uses it
+	parityMarks atomic.Value // *parityMarks
+
+	overrunDetection int32
+
+	// preserveSettings makes the open keep the current settings of the port
+	preserveSettings bool
+
+	// exclusive enables the exclusive access to the port (TIOCEXCL)
+	exclusive bool
 }
 
-// Close the serial port
+// Close the serial port. The Reads and Writes waiting on the port are
+// woken up and return an ERROR_PORT_CLOSED error (see ErrPortClosed), a
+// Write that is already transferring its data to the driver is completed
+// first.
 func (port *SerialPort) Close() error {
+	port.interrupt(ERROR_PORT_CLOSED)
+	port.closeLock.Lock()
+	defer port.closeLock.Unlock()
+	if !port.opened {
+		return nil
+	}
+	port.opened = false
+	forgetLeak(port)
+	syscall.Close(port.closeSignal[0])
+	syscall.Close(port.closeSignal[1])
 	port.releaseExclusiveAccess()
 	return syscall.Close(port.handle)
 }
 
-// Stores data received from the serial port into the provided byte array
-// buffer. The function returns the number of bytes read.
-//
-// The Read function blocks until (at least) one byte is received from
-// the serial port or an error occurs.
-func (port *SerialPort) Read(p []byte) (n int, err error) {
-	return syscall.Read(port.handle, p)
+// Reset closes and immediately reopens the serial port, using the same
+// port name and Mode it was opened with. This is useful to recover some
+// adapters from a wedged state. Reads that are waiting for data while the
+// port is reset return an ERROR_PORT_RESET error. If the port can not be
+// reopened it is left closed and the error is returned.
+func (port *SerialPort) Reset() error {
+	port.interrupt(ERROR_PORT_RESET)
+	port.closeLock.Lock()
+	defer port.closeLock.Unlock()
+	if !port.opened {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	port.releaseExclusiveAccess()
+	syscall.Close(port.handle)
+	if err := port.open(); err != nil {
+		port.opened = false
+		forgetLeak(port)
+		syscall.Close(port.closeSignal[0])
+		syscall.Close(port.closeSignal[1])
+		return err
+	}
+	port.clearInterrupt()
+	atomic.StoreInt32(&port.faulted, 0)
+	return nil
+}
+
+// reopen replaces the file descriptor of the port with a new one, the
+// port is left untouched if the device can not be opened.
+func (port *SerialPort) reopen() error {
+	port.interrupt(ERROR_PORT_RESET)
+	port.closeLock.Lock()
+	defer port.closeLock.Unlock()
+	if !port.opened {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	// the exclusive access of the old descriptor would make the open fail
+	old := port.handle
+	port.releaseExclusiveAccess()
+	if err := port.open(); err != nil {
+		port.handle = old
+		port.acquireExclusiveAccess()
+		return err
+	}
+	syscall.Close(old)
+	port.clearInterrupt()
+	return nil
+}
+
+// CancelIO aborts the Reads and Writes that are waiting for the port,
+// making them return an ERROR_CANCELED error. The port remains open and
+// can be used again. A Write that already started to transfer its buffer
+// to the driver is not interrupted.
+func (port *SerialPort) CancelIO() error {
+	port.interrupt(ERROR_CANCELED)
+	port.closeLock.Lock()
+	defer port.closeLock.Unlock()
+	if !port.opened {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	port.clearInterrupt()
+	return nil
+}
+
+// isOpen reports if the port is open
+func (port *SerialPort) isOpen() bool {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	return port.opened
+}
+
+// dupHandle returns a duplicate of the file descriptor of the port, for
+// the waits that can't be interrupted by Close or Reset: the duplicate
+// stays valid until the caller closes it.
+func (port *SerialPort) dupHandle() (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if !port.opened {
+		return -1, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	return syscall.Dup(port.handle)
+}
+
+// read reads from the serial port, removing the parity error marks if
+// enabled (see SetParityErrorReporting)
+func (port *SerialPort) read(p []byte, opts readOptions) (int, error) {
+	for {
+		n, err := port.readRaw(p, opts)
+		marks, _ := port.parityMarks.Load().(*parityMarks)
+		if marks == nil || n <= 0 {
+			return n, err
+		}
+		n, errors := marks.strip(p[:n])
+		if errors > 0 && err == nil {
+			err = &SerialPortError{code: ERROR_RX_PARITY}
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+		// only the beginning of a mark has been received
+	}
+}
+
+// readRaw waits for data (honoring the read timeout and the deadline in
+// opts) and reads it from the serial port. If the read deadline is changed
+// meanwhile the wait starts again with the new deadline.
+func (port *SerialPort) readRaw(p []byte, opts readOptions) (int, error) {
+	start := time.Now()
+	for {
+		n, err := port.readOnce(p, opts, start)
+		if err != errDeadlineChanged {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// readOnce implements readRaw for a Read started at start, it returns
+// errDeadlineChanged if the wait is interrupted by a change of the
+// deadlines
+func (port *SerialPort) readOnce(p []byte, opts readOptions, start time.Time) (n int, err error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if !port.opened {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	deadline := port.readDeadlineOf(opts.deadline)
+
+	port.timeoutsLock.Lock()
+	t, readTimeout := port.timeouts, port.readTimeout
+	port.timeoutsLock.Unlock()
+	if opts.timeoutSet {
+		t, readTimeout = nil, opts.timeout
+	}
+
+	if t != nil {
+		return port.readTimeouts(p, t, deadline, start)
+	}
+	if min := int(atomic.LoadInt32(&port.minReadBytes)); min > 1 {
+		// a total timeout without interval timeout waits for the whole buffer
+		if min < len(p) {
+			p = p[:min]
+		}
+		return port.readTimeouts(p, &Timeouts{ReadTotalConstant: readTimeout}, deadline, start)
+	}
+	if readTimeout > 0 {
+		if readTimeout -= time.Since(start); readTimeout <= 0 {
+			// just check for the bytes already received
+			readTimeout = time.Nanosecond
+		}
+	}
+	timeout, hitsDeadline := applyDeadline(readTimeout, deadline)
+	if hitsDeadline && timeout <= 0 {
+		return 0, &SerialPortError{code: ERROR_TIMEOUT}
+	}
+	ready, err := port.waitReady(false, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if !ready {
+		if hitsDeadline {
+			return 0, &SerialPortError{code: ERROR_TIMEOUT}
+		}
+		return 0, nil
+	}
+	n, err = syscall.Read(port.handle, p)
+	if err != nil {
+		// syscall reports -1 bytes on error
+		return 0, err
+	}
+	if n == 0 && len(p) > 0 {
+		// the tty has been hung up, usually because the device is gone
+		return 0, errHangup
+	}
+	return n, nil
+}
+
+// errDeadlineChanged is returned by the waits interrupted by a change of
+// the deadlines: the lock of the port must be released, so that the
+// interrupt can be cleared, and the wait started again.
+var errDeadlineChanged = errors.New("deadline changed")
+
+// errHangup is returned by read when the tty reports the end of file,
+// that happens after a hang up
+var errHangup = syscall.EIO
+
+// isHangup reports if err means that the tty has been hung up, as it
+// happens when an USB adapter is unplugged: from then on every operation
+// fails with EIO and poll always reports the port as readable, so the
+// error is reported as a disconnection without probing the device.
+func isHangup(err error) bool {
+	return err == syscall.EIO
+}
+
+// discard reads into buf the data received within timeout, ignoring the
+// read timeout and deadline of the port. It may return earlier, with no
+// data, if the deadlines are changed meanwhile.
+func (port *SerialPort) discard(buf []byte, timeout time.Duration) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if !port.opened {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	ready, err := port.waitReady(false, timeout)
+	if err == errDeadlineChanged {
+		return 0, nil
+	}
+	if err != nil || !ready {
+		return 0, err
+	}
+	n, err := syscall.Read(port.handle, buf)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// readTimeouts reads following the COMMTIMEOUTS model (see SetTimeouts),
+// for a Read started at start, until the deadline. It must be called with
+// closeLock held.
+func (port *SerialPort) readTimeouts(p []byte, t *Timeouts, deadline time.Time, start time.Time) (int, error) {
+	total := t.readTotal(len(p))
+	n := 0
+	for n < len(p) {
+		var timeout time.Duration
+		if total > 0 {
+			timeout = total - time.Since(start)
+			if timeout <= 0 {
+				break
+			}
+		}
+		if t.ReadInterval < 0 {
+			if n > 0 {
+				break
+			}
+			if total <= 0 {
+				// just check for the bytes already received
+				timeout = time.Nanosecond
+			}
+		} else if n > 0 && t.ReadInterval > 0 && (timeout <= 0 || t.ReadInterval < timeout) {
+			timeout = t.ReadInterval
+		}
+
+		timeout, hitsDeadline := applyDeadline(timeout, deadline)
+		if hitsDeadline && timeout <= 0 {
+			return n, &SerialPortError{code: ERROR_TIMEOUT}
+		}
+		ready, err := port.waitReady(false, timeout)
+		if err != nil {
+			return n, err
+		}
+		if !ready {
+			if hitsDeadline {
+				return n, &SerialPortError{code: ERROR_TIMEOUT}
+			}
+			break
+		}
+		m, err := syscall.Read(port.handle, p[n:])
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			if n == 0 {
+				return 0, errHangup
+			}
+			break
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// waitReady waits until data is available on the port (or, if forWrite is
+// true, until the port accepts data) or the timeout expires (a timeout <= 0
+// waits forever). An error is returned if the wait is interrupted by Close
+// or Reset, errDeadlineChanged if by a change of the deadlines.
+func (port *SerialPort) waitReady(forWrite bool, timeout time.Duration) (bool, error) {
+	events := int16(pollIn)
+	if forWrite {
+		events = pollOut
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		fds := []pollFd{
+			{fd: int32(port.handle), events: events},
+			{fd: int32(port.closeSignal[0]), events: pollIn},
+		}
+		remaining := time.Duration(-1)
+		if timeout > 0 {
+			remaining = deadline.Sub(time.Now())
+			if remaining < 0 {
+				remaining = 0
+			}
+		}
+		if _, err := sysPoll(fds, remaining); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return false, err
+		}
+		if fds[1].revents != 0 {
+			// a change of the deadlines doesn't matter if the port is
+			// ready or the timeout has expired
+			err := port.interruptError()
+			expired := timeout > 0 && !time.Now().Before(deadline)
+			if err != errDeadlineChanged || (fds[0].revents == 0 && !expired) {
+				return false, err
+			}
+		}
+		return fds[0].revents != 0, nil
+	}
+}
+
+// Select waits until at least one of the ports has received data, or the
+// timeout expires (a timeout <= 0 waits forever), and returns the indexes
+// in ports of those with data ready to be read. An empty list is returned
+// if the timeout expires. This allows to serve many ports from a single
+// goroutine. The ports with data in the read buffer (see
+// SetReadBufferSize) are ready too. If one of the ports is closed or
+// reset while waiting, Select returns the corresponding error.
+func Select(ports []*SerialPort, timeout time.Duration) ([]int, error) {
+	if len(ports) == 0 {
+		return nil, &SerialPortError{code: ERROR_OTHER, err: "no ports to select"}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, err := selectOnce(ports, timeout, deadline)
+		if err != errDeadlineChanged {
+			return ready, err
+		}
+	}
+}
+
+// selectOnce implements Select, it returns errDeadlineChanged if the wait
+// is interrupted by a change of the deadlines of one of the ports
+func selectOnce(ports []*SerialPort, timeout time.Duration, deadline time.Time) ([]int, error) {
+	// a port listed more times is locked once
+	locked := map[*SerialPort]bool{}
+	for _, port := range ports {
+		if locked[port] {
+			continue
+		}
+		port.closeLock.RLock()
+		defer port.closeLock.RUnlock()
+		locked[port] = true
+		if !port.opened {
+			return nil, &SerialPortError{code: ERROR_PORT_CLOSED}
+		}
+	}
+	if ready := bufferedPorts(ports); len(ready) > 0 {
+		return ready, nil
+	}
+	for {
+		fds := make([]pollFd, 0, 2*len(ports))
+		for _, port := range ports {
+			fds = append(fds,
+				pollFd{fd: int32(port.handle), events: pollIn},
+				pollFd{fd: int32(port.closeSignal[0]), events: pollIn})
+		}
+		remaining := time.Duration(-1)
+		if timeout > 0 {
+			remaining = deadline.Sub(time.Now())
+			if remaining < 0 {
+				remaining = 0
+			}
+		}
+		if _, err := sysPoll(fds, remaining); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, err
+		}
+		ready := []int{}
+		deadlineChanged := false
+		for i, port := range ports {
+			if fds[2*i+1].revents != 0 {
+				err := port.interruptError()
+				if err != errDeadlineChanged {
+					return nil, err
+				}
+				deadlineChanged = true
+			}
+			if fds[2*i].revents != 0 {
+				ready = append(ready, i)
+			}
+		}
+		// Select doesn't depend on the deadlines, but the lock of the
+		// port must be released for the interrupt to be cleared
+		if deadlineChanged && len(ready) == 0 && (timeout <= 0 || time.Now().Before(deadline)) {
+			return nil, errDeadlineChanged
+		}
+		return ready, nil
+	}
+}
+
+// timeoutsWriteChunk is the size of the chunks sent by a Write with a
+// total timeout: the timeout is checked between the chunks.
+const timeoutsWriteChunk = 64
+
+// write sends data to the serial port, if a write timeout is set with
+// SetTimeouts the data is sent in chunks until the timeout expires.
+func (port *SerialPort) write(p []byte) (int, error) {
+	t := port.writeTimeouts()
+	if t == nil || t.writeTotal(len(p)) <= 0 {
+		return port.writeChunk(p, 0)
+	}
+	deadline := time.Now().Add(t.writeTotal(len(p)))
+	n := 0
+	for n < len(p) {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return n, &SerialPortError{code: ERROR_TIMEOUT}
+		}
+		end := n + timeoutsWriteChunk
+		if end > len(p) {
+			end = len(p)
+		}
+		m, err := port.writeChunk(p[n:end], remaining)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeTimeouts returns the Timeouts in use for Write: the ones set with
+// SetTimeouts, or those made of the write timeout set with
+// SetReadWriteTimeouts (nil if not set).
+func (port *SerialPort) writeTimeouts() *Timeouts {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	if t := port.timeouts; t != nil {
+		return t
+	}
+	if port.writeTimeout > 0 {
+		return &Timeouts{WriteTotalConstant: port.writeTimeout}
+	}
+	return nil
+}
+
+// writeChunk waits until the port accepts data (or the timeout or the
+// write deadline expires) and sends data to the serial port. The wait can
+// be interrupted by Close or CancelIO, but once started the write of the
+// whole buffer is not: Close waits for it to complete.
+func (port *SerialPort) writeChunk(p []byte, timeout time.Duration) (int, error) {
+	start := time.Now()
+	for {
+		remaining := timeout
+		if timeout > 0 {
+			if remaining -= time.Since(start); remaining <= 0 {
+				return 0, &SerialPortError{code: ERROR_TIMEOUT}
+			}
+		}
+		n, err := port.writeChunkOnce(p, remaining)
+		if err != errDeadlineChanged {
+			return n, err
+		}
+	}
+}
+
+// writeChunkOnce implements writeChunk, it returns errDeadlineChanged if
+// the wait is interrupted by a change of the deadlines
+func (port *SerialPort) writeChunkOnce(p []byte, timeout time.Duration) (n int, err error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if err := port.waitWritable(timeout); err != nil {
+		return 0, err
+	}
+	n, err = syscall.Write(port.handle, p)
+	if err != nil {
+		// syscall reports -1 bytes on error
+		return 0, err
+	}
+	return n, nil
+}
+
+// waitWritable waits until the port accepts data, honoring the timeout
+// and the write deadline. It must be called with closeLock held, and the
+// lock must be kept until the data is written so that the file descriptor
+// can not be closed (and reused) in the meantime.
+func (port *SerialPort) waitWritable(timeout time.Duration) error {
+	if !port.opened {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	timeout, hitsDeadline := applyDeadline(timeout, loadDeadline(&port.writeDeadline))
+	if hitsDeadline && timeout <= 0 {
+		return &SerialPortError{code: ERROR_TIMEOUT}
+	}
+	ready, err := port.waitReady(true, timeout)
+	if err == nil && !ready {
+		err = &SerialPortError{code: ERROR_TIMEOUT}
+	}
+	return err
+}
+
+// iovMax is the maximum number of buffers accepted by writev (IOV_MAX)
+const iovMax = 1024
+
+// writev sends the buffers with a single writev system call, or one every
+// iovMax buffers. With a write timeout set with SetTimeouts the buffers are
+// joined and sent with write.
+func (port *SerialPort) writev(bufs [][]byte) (int, error) {
+	if t := port.writeTimeouts(); t != nil && t.writeTotal(1) > 0 {
+		return port.write(bytes.Join(bufs, nil))
+	}
+	iovecs := make([]syscall.Iovec, 0, len(bufs))
+	sizes := make([]int, 0, len(bufs))
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		iovec := syscall.Iovec{Base: &buf[0]}
+		iovec.SetLen(len(buf))
+		iovecs = append(iovecs, iovec)
+		sizes = append(sizes, len(buf))
+	}
+	n := 0
+	for start := 0; start < len(iovecs); start += iovMax {
+		end := start + iovMax
+		if end > len(iovecs) {
+			end = len(iovecs)
+		}
+		size := 0
+		for _, s := range sizes[start:end] {
+			size += s
+		}
+		m, err := port.writeIovecs(iovecs[start:end])
+		for err == errDeadlineChanged {
+			m, err = port.writeIovecs(iovecs[start:end])
+		}
+		n += m
+		if err != nil || m < size {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeIovecs waits until the port accepts data and sends the iovecs with
+// writev, it returns errDeadlineChanged if the wait is interrupted by a
+// change of the deadlines
+func (port *SerialPort) writeIovecs(iovecs []syscall.Iovec) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if err := port.waitWritable(0); err != nil {
+		return 0, err
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(port.handle), uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+var controlCharsMap = map[ControlChar]int{
+	CONTROLCHAR_VINTR:  syscall.VINTR,
+	CONTROLCHAR_VQUIT:  syscall.VQUIT,
+	CONTROLCHAR_VSUSP:  syscall.VSUSP,
+	CONTROLCHAR_VERASE: syscall.VERASE,
+	CONTROLCHAR_VKILL:  syscall.VKILL,
+	CONTROLCHAR_VEOF:   syscall.VEOF,
+	CONTROLCHAR_VEOL:   syscall.VEOL,
+	CONTROLCHAR_VEOL2:  syscall.VEOL2,
+	CONTROLCHAR_VSTART: syscall.VSTART,
+	CONTROLCHAR_VSTOP:  syscall.VSTOP,
+}
+
+// SetControlChar sets a special character in the termios settings of the
+// port (the c_cc array).
+func (port *SerialPort) SetControlChar(cc ControlChar, value byte) error {
+	index, ok := controlCharsMap[cc]
+	if !ok {
+		return &SerialPortError{code: ERROR_OTHER, err: "invalid control character"}
+	}
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return err
+	}
+	settings.Cc[index] = value
+	return port.setTermSettings(settings)
+}
+
+// GetControlChar returns a special character from the termios settings of
+// the port (see SetControlChar).
+func (port *SerialPort) GetControlChar(cc ControlChar) (byte, error) {
+	index, ok := controlCharsMap[cc]
+	if !ok {
+		return 0, &SerialPortError{code: ERROR_OTHER, err: "invalid control character"}
+	}
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return 0, err
+	}
+	return settings.Cc[index], nil
+}
+
+// parityMarks decodes the marks inserted by the driver in the received
+// data when PARMRK is set: a byte received with a parity or framing error
+// is preceded by 0xFF 0x00 (a break is received as 0xFF 0x00 0x00), and a
+// valid 0xFF byte is doubled.
+type parityMarks struct {
+	state int // number of bytes of a mark received so far
+}
+
+// strip removes the marks and the bytes with errors from data, in place.
+// It returns the length of the remaining data and the number of errors.
+func (m *parityMarks) strip(data []byte) (n int, errors int) {
+	for _, b := range data {
+		switch {
+		case m.state == 0 && b == 0xFF:
+			m.state = 1
+		case m.state == 0:
+			data[n] = b
+			n++
+		case m.state == 1 && b == 0x00:
+			m.state = 2
+		case m.state == 1:
+			// 0xFF 0xFF is a valid 0xFF byte
+			data[n] = b
+			n++
+			m.state = 0
+		default:
+			// the byte received with the error is dropped
+			errors++
+			m.state = 0
+		}
+	}
+	return n, errors
+}
+
+// SetParityErrorReporting enables the checking of the parity of the
+// received data and the reporting of the errors: the bytes received with
+// parity or framing errors, and the breaks, are removed from the data and
+// Read returns the valid bytes together with an ERROR_RX_PARITY error.
+// The driver marks the errors in the data stream (PARMRK) and Read strips
+// the marks. When disabled (the default) the parity is not checked and
+// the data is returned as received.
+func (port *SerialPort) SetParityErrorReporting(enabled bool) error {
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return err
+	}
+	if enabled {
+		settings.Iflag |= termiosMask(syscall.PARMRK | syscall.INPCK)
+	} else {
+		settings.Iflag &= ^termiosMask(syscall.PARMRK | syscall.INPCK)
+	}
+	if err := port.setTermSettings(settings); err != nil {
+		return err
+	}
+	if !enabled {
+		port.parityMarks.Store((*parityMarks)(nil))
+	} else if marks, _ := port.parityMarks.Load().(*parityMarks); marks == nil {
+		// the marks already enabled keep the state of a partial mark
+		port.parityMarks.Store(&parityMarks{})
+	}
+	return nil
+}
+
+// SetBreakHandling sets how a break condition received on the line is
+// handled, through the IGNBRK and BRKINT flags. The raw mode set at open
+// clears both, so the default is BREAKMODE_READ.
+func (port *SerialPort) SetBreakHandling(mode BreakMode) error {
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return err
+	}
+	settings.Iflag &= ^termiosMask(syscall.IGNBRK | syscall.BRKINT)
+	switch mode {
+	case BREAKMODE_READ:
+	case BREAKMODE_IGNORE:
+		settings.Iflag |= termiosMask(syscall.IGNBRK)
+	case BREAKMODE_INTERRUPT:
+		settings.Iflag |= termiosMask(syscall.BRKINT)
+	default:
+		return &SerialPortError{code: ERROR_OTHER, err: "Invalid break mode"}
+	}
+	return port.setTermSettings(settings)
+}
+
+// ResetInputBuffer discards the data received but not yet read. The data
+// written and not yet transmitted is not affected.
+func (port *SerialPort) ResetInputBuffer() error {
+	port.readBuffer.reset()
+	return port.flushInput()
+}
+
+// InputWaiting returns the number of bytes received and not yet read,
+// including the data kept by the read buffer (see SetReadBufferSize).
+func (port *SerialPort) InputWaiting() (int, error) {
+	var n int32
+	if err := ioctl(port.handle, ioctl_inq, uintptr(unsafe.Pointer(&n))); err != nil {
+		return 0, err
+	}
+	return int(n) + port.readBuffer.buffered(), nil
+}
+
+// NotifyTxEmpty returns a channel signaled each time the transmit queue
+// of the driver becomes empty, so that a streaming writer can push more
+// data without polling. The queue is polled every 10ms with TIOCOUTQ; the
+// data in the FIFO of the UART is not counted, so the transmission may
+// still be in progress. A signal is dropped if the previous one has not
+// been received yet. The channel is closed when ctx is canceled or the
+// port is closed.
+func (port *SerialPort) NotifyTxEmpty(ctx context.Context) (<-chan struct{}, error) {
+	if _, err := port.outputWaiting(); err != nil {
+		return nil, err
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(signalPollInterval)
+		defer ticker.Stop()
+		wasEmpty := true
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			n, err := port.outputWaiting()
+			if err != nil {
+				return
+			}
+			if n == 0 && !wasEmpty {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+			wasEmpty = n == 0
+		}
+	}()
+	return events, nil
 }
 
-// Send the content of the data byte array to the serial port.
-// Returns the number of bytes written.
-func (port *SerialPort) Write(p []byte) (n int, err error) {
-	return syscall.Write(port.handle, p)
+// outputWaiting returns the number of bytes in the transmit queue
+func (port *SerialPort) outputWaiting() (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if !port.opened {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	var n int32
+	if err := ioctl(port.handle, syscall.TIOCOUTQ, uintptr(unsafe.Pointer(&n))); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Drain waits until all the data written to the port has been
+// transmitted.
+func (port *SerialPort) Drain() error {
+	for {
+		err := ioctl(port.handle, ioctl_tcdrain, ioctl_tcdrainArg)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// SetReadTimeout sets the maximum time a Read waits for incoming data,
+// when the timeout expires Read returns 0 bytes and no error. A timeout
+// of 0 or less makes Read wait until data is received (the default).
+// SetReadTimeout replaces the timeouts set with SetTimeouts.
+func (port *SerialPort) SetReadTimeout(timeout time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	port.readTimeout = timeout
+	port.writeTimeout = 0
+	port.timeouts = nil
+	return nil
+}
+
+// SetReadWriteTimeouts sets the read timeout, as SetReadTimeout does, and
+// the total timeout of a Write at once, replacing all the timeouts set
+// before. When the write timeout expires Write returns the bytes sent so
+// far and an ERROR_TIMEOUT error; a write timeout of 0 or less makes
+// Write wait until all the data is sent (the default).
+func (port *SerialPort) SetReadWriteTimeouts(read, write time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	port.readTimeout = read
+	port.writeTimeout = write
+	port.timeouts = nil
+	return nil
+}
+
+// SetTimeouts sets the timeouts of Read and Write following the
+// COMMTIMEOUTS model of windows (see Timeouts), replacing the read timeout
+// set with SetReadTimeout. On unix the model is emulated waiting for the
+// data with poll: the zero Timeouts makes Read wait until the buffer is
+// full, and the write timeout is checked every 64 bytes sent.
+func (port *SerialPort) SetTimeouts(t Timeouts) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	port.timeouts = &t
+	port.writeTimeout = 0
+	return nil
+}
+
+// SetInterByteTimeout makes Read return when no data is received for
+// the given time after the first byte, to detect the end of a packet. The
+// read timeout set with SetReadTimeout becomes the total timeout of a Read
+// (see Timeouts): Read waits for the first byte at most for the read
+// timeout, or forever if not set. A timeout of 0 disables the inter-byte
+// timeout, call SetReadTimeout to restore the plain read timeout.
+func (port *SerialPort) SetInterByteTimeout(timeout time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	t := Timeouts{}
+	if port.timeouts != nil {
+		t = *port.timeouts
+	} else {
+		if port.readTimeout > 0 {
+			t.ReadTotalConstant = port.readTimeout
+		}
+		if port.writeTimeout > 0 {
+			t.WriteTotalConstant = port.writeTimeout
+		}
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+	t.ReadInterval = timeout
+	port.timeouts = &t
+	return nil
 }
 
 // Set all parameters of the serial port. See the Mode structure for more
 // info.
+//
+// If the driver rejects the configuration, the settings are applied one at
+// a time to find out which one is invalid and the corresponding error is
+// returned (for example ERROR_INVALID_PORT_SPEED). The port is left open
+// with the previous configuration.
+//
+// SetMode can be called while a Read is waiting for data in another
+// goroutine, the new settings apply to the data received afterwards.
 func (port *SerialPort) SetMode(mode *Mode) error {
+	if err := port.setMode(mode); err != nil {
+		return withPortName("setmode", port.name, port.checkDisconnected(err))
+	}
+	if err := port.checkAppliedMode(mode); err != nil {
+		return withPortName("setmode", port.name, err)
+	}
+	return port.restoreModemLines(mode)
+}
+
+// restoreModemLines sets again the DTR and RTS lines to the levels set
+// with SetDTR and SetRTS, if the change of the settings altered them (as
+// some drivers do). The lines driven by the flow control are not touched.
+func (port *SerialPort) restoreModemLines(mode *Mode) error {
+	status, err := port.getModemBits()
+	if err != nil {
+		// not supported by the device (like a pty)
+		return nil
+	}
+	if mode.FlowControl != FLOWCONTROL_DTRDSR && (status&syscall.TIOCM_DTR != 0) != port.dtr {
+		if err := port.SetDTR(port.dtr); err != nil {
+			return err
+		}
+	}
+	if mode.FlowControl != FLOWCONTROL_RTSCTS && (status&syscall.TIOCM_RTS != 0) != port.rts {
+		return port.SetRTS(port.rts)
+	}
+	return nil
+}
+
+// setMode applies the mode to the port, see SetMode
+func (port *SerialPort) setMode(mode *Mode) error {
 	settings, err := port.getTermSettings()
 	if err != nil {
 		return err
 	}
-	if err := setTermSettingsBaudrate(mode.BaudRate, settings); err != nil {
+	original := *settings
+	if err := setTermSettingsMode(mode, settings); err != nil {
 		return err
 	}
-	if err := setTermSettingsParity(mode.Parity, settings); err != nil {
+	if err := port.setTermSettings(settings); err != nil {
+		if err == syscall.EINVAL {
+			serr := isolateInvalidSetting(mode, func(m *Mode) error {
+				s := original
+				if err := setTermSettingsMode(m, &s); err != nil {
+					return err
+				}
+				return port.setTermSettings(&s)
+			})
+			port.setTermSettings(&original)
+			if serr != nil {
+				return serr
+			}
+		}
 		return err
 	}
-	if err := setTermSettingsDataBits(mode.DataBits, settings); err != nil {
+	port.mode = *mode
+	return nil
+}
+
+// GetMode returns the configuration currently applied to the serial port,
+// as reported by the driver. This may differ from the Mode used to open
+// the port, if it has been changed by another process.
+func (port *SerialPort) GetMode() (*Mode, error) {
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return nil, err
+	}
+	return getTermSettingsMode(settings), nil
+}
+
+// ResetToDefaults restores the configuration of a port just opened with
+// an empty Mode: 9600_N81, no flow control, raw mode, DTR and RTS
+// asserted, break cleared and no read timeout. The port is not closed.
+func (port *SerialPort) ResetToDefaults() error {
+	mode := &Mode{}
+	if err := port.SetMode(mode); err != nil {
 		return err
 	}
-	if err := setTermSettingsStopBits(mode.StopBits, settings); err != nil {
+	settings, err := port.getTermSettings()
+	if err != nil {
 		return err
 	}
-	return port.setTermSettings(settings)
+	setRawMode(settings, mode)
+	if err := port.setTermSettings(settings); err != nil {
+		return err
+	}
+	port.parityMarks.Store((*parityMarks)(nil))
+	port.SetReadTimeout(0)
+	if err := port.SetBreak(false); err != nil {
+		return err
+	}
+	if err := port.SetDTR(true); err != nil {
+		return err
+	}
+	return port.SetRTS(true)
+}
+
+// SetHardwareFlowControl enables or disables the RTS/CTS flow control,
+// without changing the other settings of the port.
+func (port *SerialPort) SetHardwareFlowControl(on bool) error {
+	settings, err := port.getTermSettings()
+	if err != nil {
+		return err
+	}
+	if on {
+		settings.Cflag |= tc_CRTSCTS
+	} else {
+		settings.Cflag &= ^tc_CRTSCTS
+	}
+	if err := port.setTermSettings(settings); err != nil {
+		return err
+	}
+	if on {
+		port.mode.FlowControl = FLOWCONTROL_RTSCTS
+	} else if port.mode.FlowControl == FLOWCONTROL_RTSCTS {
+		port.mode.FlowControl = FLOWCONTROL_NONE
+	}
+	return nil
 }
 
 // Open the serial port using the specified modes
 func OpenPort(portName string, mode *Mode) (*SerialPort, error) {
-	h, err := syscall.Open(portName, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NDELAY, 0)
+	port, err := openSerialPort(portName, &openOptions{mode: *mode})
+	return port, withPortName("open", portName, err)
+}
+
+// OpenPTY allocates a pseudo-terminal pair, opens the master side as a
+// SerialPort and returns it together with the name of the slave device:
+// the code under test can open the slave with OpenPort and talk to the
+// master like with a device connected to a real serial link. This allows
+// to test without hardware. The termios settings apply to the pair, the
+// modem lines are not emulated. Reset can't be used on the master, it
+// would allocate a new pair.
+func OpenPTY() (*SerialPort, string, error) {
+	port, err := openSerialPort("/dev/ptmx", &openOptions{})
+	if err != nil {
+		return nil, "", withPortName("open", "/dev/ptmx", err)
+	}
+	slaveName, err := ptsName(port.handle)
+	if err != nil {
+		port.Close()
+		return nil, "", err
+	}
+	return port, slaveName, nil
+}
+
+func openSerialPort(portName string, opts *openOptions) (*SerialPort, error) {
+	port := &SerialPort{
+		name:   portName,
+		mode:   opts.mode,
+		access: opts.access,
+		dtr:    true,
+		rts:    true,
+
+		preserveSettings: opts.preserveSettings,
+		exclusive:        opts.exclusive,
+	}
+	port.probeSettings.Store(defaultDisconnectProbe)
+	if err := port.open(); err != nil {
+		return nil, err
+	}
+	if err := syscall.Pipe(port.closeSignal[:]); err != nil {
+		port.releaseExclusiveAccess()
+		syscall.Close(port.handle)
+		return nil, err
+	}
+	port.opened = true
+	watchLeak(port)
+	return port, nil
+}
+
+// open opens the device port.name and configures it using port.mode
+func (port *SerialPort) open() error {
+	flags := syscall.O_RDWR
+	switch port.access {
+	case ACCESS_READ_ONLY:
+		flags = syscall.O_RDONLY
+	case ACCESS_WRITE_ONLY:
+		flags = syscall.O_WRONLY
+	}
+	h, err := syscall.Open(port.name, flags|syscall.O_NOCTTY|syscall.O_NDELAY, 0)
 	if err != nil {
 		switch err {
 		case syscall.EBUSY:
-			return nil, &SerialPortError{code: ERROR_PORT_BUSY}
+			return &SerialPortError{code: ERROR_PORT_BUSY}
 		case syscall.EACCES:
-			return nil, &SerialPortError{code: ERROR_PERMISSION_DENIED}
+			return &SerialPortError{code: ERROR_PERMISSION_DENIED}
 		}
-		return nil, err
+		return err
 	}
-	port := &SerialPort{
-		handle: h,
+	port.handle = h
+
+	if port.preserveSettings {
+		settings, err := port.getTermSettings()
+		if err != nil {
+			syscall.Close(h)
+			return &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+		}
+		port.mode = *getTermSettingsMode(settings)
+		syscall.SetNonblock(h, false)
+		port.acquireExclusiveAccess()
+		return nil
 	}
 
 	// Setup serial port
-	if port.SetMode(mode) != nil {
-		port.Close()
-		return nil, &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+	mode := port.mode
+	if err := port.setMode(&mode); err != nil && !isRFCOMM(port.name) {
+		// RFCOMM ports ignore the line settings, and some of them even
+		// refuse them: the error is meaningless in that case.
+		syscall.Close(h)
+		if serr, ok := err.(*SerialPortError); ok {
+			return serr
+		}
+		return &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
 	}
 
 	// Set raw mode
 	settings, err := port.getTermSettings()
 	if err != nil {
-		port.Close()
-		return nil, &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+		syscall.Close(h)
+		return &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
 	}
-	setRawMode(settings, mode)
-	if port.setTermSettings(settings) != nil {
-		port.Close()
-		return nil, &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
+	setRawMode(settings, &mode)
+	if port.setTermSettings(settings) != nil && !isRFCOMM(port.name) {
+		syscall.Close(h)
+		return &SerialPortError{code: ERROR_INVALID_SERIAL_PORT}
 	}
 
 	syscall.SetNonblock(h, false)
 
 	port.acquireExclusiveAccess()
 
-	return port, nil
+	return nil
+}
+
+// interrupt wakes up any Read waiting for data, making it return
+// an error with the given code.
+func (port *SerialPort) interrupt(code int) {
+	port.closeLock.RLock()
+	// set under the lock, so that clearInterrupt can't reset it before
+	// the signal is sent
+	atomic.StoreInt32(&port.interruptCode, int32(code))
+	if port.opened {
+		syscall.Write(port.closeSignal[1], []byte{0})
+	}
+	port.closeLock.RUnlock()
+}
+
+// clearInterrupt consumes a pending signal sent by interrupt or by
+// deadlineChanged. It must be called with closeLock held for writing.
+func (port *SerialPort) clearInterrupt() {
+	fds := []pollFd{{fd: int32(port.closeSignal[0]), events: pollIn}}
+	if n, err := sysPoll(fds, 0); err == nil && n > 0 {
+		buf := make([]byte, 16)
+		syscall.Read(port.closeSignal[0], buf)
+	}
+	atomic.StoreInt32(&port.interruptCode, 0)
+}
+
+// interruptError returns the error of a wait woken up by closeSignal: the
+// one set by interrupt, or errDeadlineChanged if no interrupt is pending.
+func (port *SerialPort) interruptError() error {
+	code := int(atomic.LoadInt32(&port.interruptCode))
+	if code == 0 {
+		return errDeadlineChanged
+	}
+	return &SerialPortError{code: code}
+}
+
+// deadlineChanged wakes up the Reads and Writes waiting on the port, that
+// wait again with the new deadlines.
+func (port *SerialPort) deadlineChanged() {
+	port.closeLock.RLock()
+	opened := port.opened
+	if opened {
+		syscall.Write(port.closeSignal[1], []byte{0})
+	}
+	port.closeLock.RUnlock()
+	if !opened {
+		return
+	}
+	// the waits release the lock once woken up
+	port.closeLock.Lock()
+	if port.opened {
+		port.clearInterrupt()
+	}
+	port.closeLock.Unlock()
 }
 
+// GetPortsList returns the list of the serial ports available on the
+// system. On unix the names are the full paths of the devices (like
+// /dev/ttyUSB0) and can be passed as-is to OpenPort. On darwin only the
+// callout devices (/dev/cu.*) are listed: the corresponding dial-in
+// devices (/dev/tty.*) wait for the carrier detect signal and are not
+// suited to talk to a device.
 func GetPortsList() ([]string, error) {
 	files, err := ioutil.ReadDir(devFolder)
 	if err != nil {
@@ -149,6 +1289,94 @@ func GetPortsList() ([]string, error) {
 
 // termios manipulation functions
 
+func setTermSettingsMode(mode *Mode, settings *syscall.Termios) error {
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+	if err := setTermSettingsBaudrate(mode.BaudRate, settings); err != nil {
+		return err
+	}
+	if err := setTermSettingsParity(mode.Parity, settings); err != nil {
+		return err
+	}
+	if err := setTermSettingsDataBits(mode.DataBits, settings); err != nil {
+		return err
+	}
+	if err := setTermSettingsStopBits(mode.StopBits, settings); err != nil {
+		return err
+	}
+	if mode.StripParity {
+		settings.Iflag |= termiosMask(syscall.ISTRIP)
+	} else {
+		settings.Iflag &= ^termiosMask(syscall.ISTRIP)
+	}
+	setTermSettingsTranslateCRLF(mode.TranslateCRLF, settings)
+	return setTermSettingsFlowControl(mode.FlowControl, settings)
+}
+
+func setTermSettingsTranslateCRLF(translate bool, settings *syscall.Termios) {
+	if translate {
+		settings.Iflag |= termiosMask(syscall.ICRNL)
+		settings.Oflag |= termiosMask(syscall.OPOST | syscall.ONLCR)
+	} else {
+		settings.Iflag &= ^termiosMask(syscall.ICRNL)
+		settings.Oflag &= ^termiosMask(syscall.OPOST | syscall.ONLCR)
+	}
+}
+
+// getTermSettingsMode decodes the termios settings into a Mode
+func getTermSettingsMode(settings *syscall.Termios) *Mode {
+	mode := &Mode{
+		Vmin:     settings.Cc[syscall.VMIN],
+		Vtimeout: settings.Cc[syscall.VTIME],
+
+		StripParity:   settings.Iflag&termiosMask(syscall.ISTRIP) != 0,
+		TranslateCRLF: settings.Iflag&termiosMask(syscall.ICRNL) != 0 && settings.Oflag&termiosMask(syscall.OPOST|syscall.ONLCR) == termiosMask(syscall.OPOST|syscall.ONLCR),
+	}
+	speed := termiosSpeed(settings)
+	for baud, rate := range baudrateMap {
+		if baud != 0 && rate == speed {
+			mode.BaudRate = baud
+		}
+	}
+	for bits, size := range databitsMap {
+		if bits != 0 && settings.Cflag&termiosMask(syscall.CSIZE) == termiosMask(size) {
+			mode.DataBits = bits
+		}
+	}
+	switch {
+	case settings.Cflag&termiosMask(syscall.PARENB) == 0:
+		mode.Parity = PARITY_NONE
+	case tc_CMSPAR != 0 && settings.Cflag&termiosMask(tc_CMSPAR) != 0:
+		if settings.Cflag&termiosMask(syscall.PARODD) != 0 {
+			mode.Parity = PARITY_MARK
+		} else {
+			mode.Parity = PARITY_SPACE
+		}
+	case settings.Cflag&termiosMask(syscall.PARODD) != 0:
+		mode.Parity = PARITY_ODD
+	default:
+		mode.Parity = PARITY_EVEN
+	}
+	if settings.Cflag&termiosMask(syscall.CSTOPB) != 0 {
+		// with 5 data bits the UART sends 1.5 stop bits
+		if mode.DataBits == 5 {
+			mode.StopBits = STOPBITS_ONEPOINTFIVE
+		} else {
+			mode.StopBits = STOPBITS_TWO
+		}
+	}
+	switch {
+	case settings.Cflag&tc_CRTSCTS != 0:
+		mode.FlowControl = FLOWCONTROL_RTSCTS
+	case tc_CDTRDSR != 0 && settings.Cflag&tc_CDTRDSR != 0:
+		mode.FlowControl = FLOWCONTROL_DTRDSR
+	case settings.Iflag&termiosMask(syscall.IXON|syscall.IXOFF) != 0:
+		mode.FlowControl = FLOWCONTROL_XONXOFF
+	}
+	return mode
+}
+
 func setTermSettingsBaudrate(speed int, settings *syscall.Termios) error {
 	baudrate, ok := baudrateMap[speed]
 	if !ok {
@@ -167,6 +1395,49 @@ func setTermSettingsBaudrate(speed int, settings *syscall.Termios) error {
 	return nil
 }
 
+// markSpaceParity is true if PARITY_MARK and PARITY_SPACE are supported
+const markSpaceParity = tc_CMSPAR != 0
+
+// dtrDsrFlowControl is true if FLOWCONTROL_DTRDSR is supported
+const dtrDsrFlowControl = tc_CDTRDSR != 0
+
+// Capabilities returns the settings supported by the port. On unix the
+// drivers don't report them, so the settings supported by the platform
+// are returned; the driver may still reject some of them. The maximum
+// baud rate is set if known (see MaxBaudRate), the queue sizes are not
+// available.
+func (port *SerialPort) Capabilities() (PortCapabilities, error) {
+	caps := PortCapabilities{
+		DataBits:     []int{5, 6, 7, 8},
+		Parities:     []Parity{PARITY_NONE, PARITY_ODD, PARITY_EVEN},
+		StopBits:     []StopBits{STOPBITS_ONE, STOPBITS_ONEPOINTFIVE, STOPBITS_TWO},
+		FlowControls: []FlowControl{FLOWCONTROL_NONE, FLOWCONTROL_RTSCTS, FLOWCONTROL_XONXOFF},
+	}
+	for speed := range baudrateMap {
+		if speed > 0 {
+			caps.BaudRates = append(caps.BaudRates, speed)
+		}
+	}
+	sort.Ints(caps.BaudRates)
+	if markSpaceParity {
+		caps.Parities = append(caps.Parities, PARITY_MARK, PARITY_SPACE)
+	}
+	if dtrDsrFlowControl {
+		caps.FlowControls = append(caps.FlowControls, FLOWCONTROL_DTRDSR)
+	}
+	if max, err := port.MaxBaudRate(); err == nil {
+		caps.MaxBaudRate = max
+	}
+	return caps, nil
+}
+
+// validBaudRate reports if speed is one of the standard baud rates (0
+// selects the default)
+func validBaudRate(speed int) bool {
+	_, ok := baudrateMap[speed]
+	return ok
+}
+
 func setTermSettingsParity(parity Parity, settings *syscall.Termios) error {
 	switch parity {
 	case PARITY_NONE:
@@ -206,11 +1477,29 @@ func setTermSettingsStopBits(bits StopBits, settings *syscall.Termios) error {
 	case STOPBITS_ONE:
 		settings.Cflag &= ^termiosMask(syscall.CSTOPB)
 	case STOPBITS_ONEPOINTFIVE, STOPBITS_TWO:
+		// CSTOPB means 1.5 stop bits with 5 data bits, 2 otherwise
 		settings.Cflag |= termiosMask(syscall.CSTOPB)
 	}
 	return nil
 }
 
+func setTermSettingsFlowControl(flow FlowControl, settings *syscall.Termios) error {
+	settings.Cflag &= ^(tc_CRTSCTS | tc_CDTRDSR)
+	settings.Iflag &= ^termiosMask(syscall.IXON | syscall.IXOFF)
+	switch flow {
+	case FLOWCONTROL_RTSCTS:
+		settings.Cflag |= tc_CRTSCTS
+	case FLOWCONTROL_XONXOFF:
+		settings.Iflag |= termiosMask(syscall.IXON | syscall.IXOFF)
+	case FLOWCONTROL_DTRDSR:
+		if tc_CDTRDSR == 0 {
+			return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+		}
+		settings.Cflag |= tc_CDTRDSR
+	}
+	return nil
+}
+
 func setRawMode(settings *syscall.Termios, mode *Mode) {
 	// Set local mode
 	settings.Cflag |= termiosMask(syscall.CREAD | syscall.CLOCAL)
@@ -218,8 +1507,8 @@ func setRawMode(settings *syscall.Termios, mode *Mode) {
 	// Set raw mode
 	settings.Lflag &= ^termiosMask(syscall.ICANON | syscall.ECHO | syscall.ECHOE | syscall.ECHOK |
 		syscall.ECHONL | syscall.ECHOCTL | syscall.ECHOPRT | syscall.ECHOKE | syscall.ISIG | syscall.IEXTEN)
-	settings.Iflag &= ^termiosMask(syscall.IXON | syscall.IXOFF | syscall.IXANY | syscall.INPCK |
-		syscall.IGNPAR | syscall.PARMRK | syscall.ISTRIP | syscall.IGNBRK | syscall.BRKINT | syscall.INLCR |
+	settings.Iflag &= ^termiosMask(syscall.IXANY | syscall.INPCK |
+		syscall.IGNPAR | syscall.PARMRK | syscall.IGNBRK | syscall.BRKINT | syscall.INLCR |
 		syscall.IGNCR | syscall.ICRNL | tc_IUCLC)
 	settings.Oflag &= ^termiosMask(syscall.OPOST)
 
@@ -231,6 +1520,9 @@ func setRawMode(settings *syscall.Termios, mode *Mode) {
 
 	settings.Cc[syscall.VMIN] = mode.Vmin
 	settings.Cc[syscall.VTIME] = mode.Vtimeout
+
+	// the only translation allowed by the Mode
+	setTermSettingsTranslateCRLF(mode.TranslateCRLF, settings)
 }
 
 // native syscall wrapper functions
@@ -245,24 +1537,133 @@ func (port *SerialPort) setTermSettings(settings *syscall.Termios) error {
 	return ioctl(port.handle, ioctl_tcsetattr, uintptr(unsafe.Pointer(settings)))
 }
 
+// probe checks if the device is still responding
+func (port *SerialPort) probe() error {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if !port.opened {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	_, err := port.getTermSettings()
+	return err
+}
+
 func (port *SerialPort) acquireExclusiveAccess() error {
+	if !port.exclusive {
+		return nil
+	}
 	return ioctl(port.handle, syscall.TIOCEXCL, 0)
 }
 
 func (port *SerialPort) releaseExclusiveAccess() error {
+	if !port.exclusive {
+		return nil
+	}
 	return ioctl(port.handle, syscall.TIOCNXCL, 0)
 }
 
+// pollFd is the struct pollfd of poll(2). poll is used instead of select
+// since select can't wait for the file descriptors >= FD_SETSIZE (1024).
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollIn = 0x1
+const pollOut = 0x4
+
+// supportsRTSCTS reports if the driver supports the RTS/CTS flow control,
+// the termios drivers don't tell
+func (port *SerialPort) supportsRTSCTS() (bool, error) {
+	return true, nil
+}
+
+// Set the DTR (Data Terminal Ready) line to the given level
 func (port *SerialPort) SetDTR(level bool) error {
-	var status uint
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(port.handle), uintptr(syscall.TIOCMGET), uintptr(unsafe.Pointer(&status)))
-	if err == 0 {
-		if level == false {
-			status &= ^uint(syscall.TIOCM_DTR)
-		} else {
-			status |= syscall.TIOCM_DTR
+	if err := port.setModemControl(syscall.TIOCM_DTR, level); err != nil {
+		return err
+	}
+	port.dtr = level
+	return nil
+}
+
+// Set the RTS (Request To Send) line to the given level
+func (port *SerialPort) SetRTS(level bool) error {
+	if err := port.setModemControl(syscall.TIOCM_RTS, level); err != nil {
+		return err
+	}
+	port.rts = level
+	return nil
+}
+
+// ModemControlState returns the current level of the DTR and RTS output
+// lines. The levels are read back from the driver when possible, otherwise
+// the last values set with SetDTR and SetRTS are returned.
+func (port *SerialPort) ModemControlState() (dtr, rts bool) {
+	status, err := port.getModemBits()
+	if err != nil {
+		return port.dtr, port.rts
+	}
+	return status&syscall.TIOCM_DTR != 0, status&syscall.TIOCM_RTS != 0
+}
+
+// SetBreak starts (true) or stops (false) sending a break condition
+func (port *SerialPort) SetBreak(on bool) error {
+	req := syscall.TIOCCBRK
+	if on {
+		req = syscall.TIOCSBRK
+	}
+	if err := ioctl(port.handle, uint64(req), 0); err != nil {
+		return port.checkDisconnected(err)
+	}
+	port.brk = on
+	return nil
+}
+
+// getModemStatus returns the level of an input line
+func (port *SerialPort) getModemStatus(sig Signal) (bool, error) {
+	var bit uint
+	switch sig {
+	case SIGNAL_CTS:
+		bit = syscall.TIOCM_CTS
+	case SIGNAL_DSR:
+		bit = syscall.TIOCM_DSR
+	case SIGNAL_RI:
+		bit = syscall.TIOCM_RI
+	case SIGNAL_DCD:
+		bit = syscall.TIOCM_CD
+	default:
+		return false, &SerialPortError{code: ERROR_OTHER, err: "invalid signal"}
+	}
+	status, err := port.getModemBits()
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		// some UART drivers don't implement TIOCMGET
+		if level, ferr := port.modemStatusFallback(sig); ferr == nil {
+			return level, nil
 		}
-		_, _, err = syscall.Syscall(syscall.SYS_IOCTL, uintptr(port.handle), uintptr(syscall.TIOCMSET), uintptr(unsafe.Pointer(&status)))
 	}
-	return err
+	if err != nil {
+		return false, err
+	}
+	return status&bit != 0, nil
+}
+
+func (port *SerialPort) getModemBits() (uint, error) {
+	var status uint
+	err := ioctl(port.handle, syscall.TIOCMGET, uintptr(unsafe.Pointer(&status)))
+	return status, err
+}
+
+func (port *SerialPort) setModemControl(bit uint, level bool) error {
+	status, err := port.getModemBits()
+	if err != nil {
+		return err
+	}
+	if level == false {
+		status &= ^bit
+	} else {
+		status |= bit
+	}
+	return ioctl(port.handle, syscall.TIOCMSET, uintptr(unsafe.Pointer(&status)))
 }
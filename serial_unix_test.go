@@ -0,0 +1,265 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// +build linux darwin
+
+package serial
+
+import "context"
+import "errors"
+import "testing"
+import "time"
+
+// openPTYPair opens a pseudo-terminal: the slave is the port under test,
+// the master plays the device
+func openPTYPair(t *testing.T) (master, slave *SerialPort) {
+	master, slaveName, err := OpenPTY()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, err = OpenPort(slaveName, &Mode{BaudRate: 9600})
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	return master, slave
+}
+
+// readAsync starts a Read of port and returns the channel receiving its
+// error
+func readAsync(port *SerialPort) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := port.Read(make([]byte, 16))
+		done <- err
+	}()
+	return done
+}
+
+func TestCloseWakesUpRead(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	done := readAsync(slave)
+	time.Sleep(50 * time.Millisecond)
+	if err := slave.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPortClosed) {
+			t.Errorf("Read returned %v, want ErrPortClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read not woken up by Close")
+	}
+}
+
+func TestReadBlocksOnIdleLine(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	done := readAsync(slave)
+	select {
+	case err := <-done:
+		t.Fatalf("Read returned %v on an idle line", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	slave.Close()
+	<-done
+}
+
+func TestReadTimeout(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	defer slave.Close()
+	if err := slave.SetReadTimeout(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if n, err := slave.Read(buf); n != 0 || err != nil {
+		t.Errorf("Read returned (%d, %v), want (0, nil)", n, err)
+	}
+	slave.SetReadTimeoutError(true)
+	if n, err := slave.Read(buf); n != 0 || !errors.Is(err, ErrTimeout) {
+		t.Errorf("Read returned (%d, %v), want (0, ErrTimeout)", n, err)
+	}
+}
+
+func TestReadReportsDisconnection(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer slave.Close()
+	done := readAsync(slave)
+	time.Sleep(50 * time.Millisecond)
+	master.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPortDisconnected) {
+			t.Errorf("Read returned %v, want ErrPortDisconnected", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read not woken up by the hang up")
+	}
+}
+
+func TestReadAfterHangup(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer slave.Close()
+	if err := slave.SetReadTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	master.Close()
+	buf := make([]byte, 16)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		n, err := slave.Read(buf)
+		if n != 0 || !errors.Is(err, ErrPortDisconnected) {
+			t.Fatalf("Read %d returned (%d, %v), want (0, ErrPortDisconnected)", i, n, err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("Read %d took %v to report the hang up", i, elapsed)
+		}
+	}
+}
+
+func TestRingReaderStopsOnHangup(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	r := master.StartRingBufferReader(64)
+	defer r.Close()
+	time.Sleep(50 * time.Millisecond)
+	slave.Close()
+	select {
+	case <-r.done:
+	case <-time.After(time.Second):
+		t.Fatal("RingReader not stopped by the hang up")
+	}
+	if err := r.Err(); !errors.Is(err, ErrPortDisconnected) {
+		t.Errorf("Err returned %v, want ErrPortDisconnected", err)
+	}
+	if n, err := master.Read(make([]byte, 16)); n != 0 || err == nil {
+		t.Errorf("Read returned (%d, %v), want (0, error)", n, err)
+	}
+}
+
+func TestWriteAfterHangup(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer slave.Close()
+	master.Close()
+	if n, err := slave.Write([]byte("hello")); n != 0 || !errors.Is(err, ErrPortDisconnected) {
+		t.Errorf("Write returned (%d, %v), want (0, ErrPortDisconnected)", n, err)
+	}
+	n, err := slave.WriteContext(context.Background(), make([]byte, 2*writeContextChunk))
+	if n != 0 || err == nil {
+		t.Errorf("WriteContext returned (%d, %v), want (0, error)", n, err)
+	}
+}
+
+func TestDeadlineWakesUpRead(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	defer slave.Close()
+	done := readAsync(slave)
+	time.Sleep(50 * time.Millisecond)
+	// a later deadline keeps the Read waiting
+	slave.SetReadDeadline(time.Now().Add(time.Hour))
+	select {
+	case err := <-done:
+		t.Fatalf("Read returned %v before the deadline", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	slave.SetReadDeadline(time.Now().Add(-time.Second))
+	select {
+	case err := <-done:
+		if serr, ok := err.(*SerialPortError); !ok || !serr.Timeout() {
+			t.Errorf("Read returned %v, want a timeout error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read not woken up by the deadline")
+	}
+	// the port is still usable once the deadline is cleared
+	slave.SetReadDeadline(time.Time{})
+	master.Write([]byte("x"))
+	if n, err := slave.Read(make([]byte, 16)); n != 1 || err != nil {
+		t.Errorf("Read returned (%d, %v), want (1, nil)", n, err)
+	}
+}
+
+func TestWritevManyBuffers(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	defer slave.Close()
+	bufs := make([][]byte, 3*iovMax/2)
+	for i := range bufs {
+		bufs[i] = []byte{byte('a' + i%26)}
+	}
+	if n, err := slave.Writev(bufs); n != len(bufs) || err != nil {
+		t.Fatalf("Writev returned (%d, %v), want (%d, nil)", n, err, len(bufs))
+	}
+	master.SetReadTimeout(100 * time.Millisecond)
+	received := []byte{}
+	buf := make([]byte, 256)
+	for len(received) < len(bufs) {
+		n, err := master.Read(buf)
+		if err != nil || n == 0 {
+			t.Fatalf("received %d bytes, want %d (%v)", len(received), len(bufs), err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	for i, b := range received {
+		if b != bufs[i][0] {
+			t.Fatalf("byte %d is %q, want %q", i, b, bufs[i][0])
+		}
+	}
+}
+
+func TestResetInputBufferKeepsOutput(t *testing.T) {
+	master, slave := openPTYPair(t)
+	defer master.Close()
+	defer slave.Close()
+	master.Write([]byte("stale"))
+	slave.Write([]byte("hello"))
+	time.Sleep(20 * time.Millisecond)
+	if err := slave.ResetInputBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	master.SetReadTimeout(100 * time.Millisecond)
+	buf := make([]byte, 16)
+	if n, err := master.Read(buf); string(buf[:n]) != "hello" || err != nil {
+		t.Errorf("the peer received (%q, %v), want \"hello\"", buf[:n], err)
+	}
+	slave.SetReadTimeout(50 * time.Millisecond)
+	if n, err := slave.Read(buf); n != 0 || err != nil {
+		t.Errorf("Read returned (%q, %v) after ResetInputBuffer", buf[:n], err)
+	}
+}
+
+func TestStripParity(t *testing.T) {
+	tests := []struct {
+		strip bool
+		want  byte
+	}{
+		{false, 0xC1},
+		{true, 0x41},
+	}
+	for _, test := range tests {
+		master, slave := openPTYPair(t)
+		// 7E1: the parity bit of 'A' is received as the 8th bit
+		mode := &Mode{BaudRate: 9600, DataBits: 7, Parity: PARITY_EVEN, StripParity: test.strip}
+		if err := slave.SetMode(mode); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := slave.GetMode(); err != nil || got.StripParity != test.strip {
+			t.Errorf("GetMode returned (%+v, %v), want StripParity %v", got, err, test.strip)
+		}
+		master.Write([]byte{0xC1})
+		slave.SetReadTimeout(100 * time.Millisecond)
+		buf := make([]byte, 1)
+		if n, err := slave.Read(buf); n != 1 || err != nil || buf[0] != test.want {
+			t.Errorf("StripParity %v: Read returned (%#x, %v), want %#x", test.strip, buf[:n], err, test.want)
+		}
+		master.Close()
+		slave.Close()
+	}
+}
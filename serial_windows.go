@@ -3,16 +3,71 @@
 package serial
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
 )
 
 type SerialPort struct {
-	p *Port
+	// overruns cleared by commStatus, not yet reported by Read. It's
+	// accessed atomically and comes first to be 64-bit aligned on the
+	// 32-bit platforms.
+	overruns int64
+
+	p      *Port
+	name   string
+	mode   Mode
+	access AccessMode
+
+	// pLock guards p, which is replaced by Reset
+	pLock sync.Mutex
+
+	// last levels set on the DTR, RTS and break lines
+	dtr, rts, brk bool
+
+	latency        latencyRecorder
+	latencyEnabled int32
+	tracer         atomic.Value
+
+	timeouts      *structTimeouts
+	readDeadline  atomic.Value // time.Time
+	writeDeadline atomic.Value // time.Time
+
+	// timeoutsLock serializes the changes of the timeouts
+	timeoutsLock sync.Mutex
+
+	readBuffer readBuffer
+
+	// the settings below can be changed while a Read or Write is in
+	// progress, they are accessed atomically
+	probeSettings atomic.Value // disconnectProbe
+	faulted       int32
+	errorPolicy   int32 // ErrorPolicy
+	maxReadChunk  int32
+
+	rs485            atomic.Value // *manualRS485
+	readTimeoutError int32
+	minReadBytes     int32
+	strictMode       int32
+
+	fanout fanout
+
+	overrunDetection int32
+
+	// preserveSettings makes the open keep the current settings of the port
+	preserveSettings bool
+
+	// serial number of the USB adapter providing the port, used to find
+	// the port again if it's renamed when the adapter is re-enumerated.
+	// It's looked up only the first time the port can't be reopened.
+	serialNumber string
 }
 
 type Port struct {
@@ -22,17 +77,47 @@ type Port struct {
 	wl sync.Mutex
 	ro *syscall.Overlapped
 	wo *syscall.Overlapped
+
+	// cl is held while a read is issued and while the port is
+	// reconfigured, reconfigs counts the reconfigurations (see reconfigure)
+	cl        sync.Mutex
+	reconfigs uint32
+
+	// deadlines counts the changes of the deadlines, that cancel the
+	// pending reads and writes (see deadlineChanged)
+	deadlines uint32
+
+	// eventWait is set while WaitCommEvent is in use, see acquireEventWait
+	eventWait int32
 }
 
 type structDCB struct {
 	DCBlength, BaudRate                            uint32
-	flags                                          [4]byte
+	flags                                          uint32
 	wReserved, XonLim, XoffLim                     uint16
 	ByteSize, Parity, StopBits                     byte
 	XonChar, XoffChar, ErrorChar, EofChar, EvtChar byte
 	wReserved1                                     uint16
 }
 
+type structCommProp struct {
+	PacketLength, PacketVersion      uint16
+	ServiceMask, Reserved1           uint32
+	MaxTxQueue, MaxRxQueue, MaxBaud  uint32
+	ProvSubType, ProvCapabilities    uint32
+	SettableParams, SettableBaud     uint32
+	SettableData, SettableStopParity uint16
+	CurrentTxQueue, CurrentRxQueue   uint32
+	ProvSpec1, ProvSpec2             uint32
+	ProvChar                         [1]uint16
+}
+
+type structComStat struct {
+	flags    uint32
+	InQueue  uint32
+	OutQueue uint32
+}
+
 type structTimeouts struct {
 	ReadIntervalTimeout         uint32
 	ReadTotalTimeoutMultiplier  uint32
@@ -41,7 +126,31 @@ type structTimeouts struct {
 	WriteTotalTimeoutConstant   uint32
 }
 
+// GetPortsList returns the list of the serial ports available on the
+// system. On windows the names are the bare port names (like COM3), they
+// can be passed as-is to OpenPort that takes care of adding the \\.\
+// prefix required to open the ports above COM9.
 func GetPortsList() ([]string, error) {
+	entries, err := getSerialCommEntries()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, len(entries))
+	for i, entry := range entries {
+		list[i] = entry.port
+	}
+	return list, nil
+}
+
+// serialCommEntry is a value of the SERIALCOMM registry key, it maps the
+// name of the device driving the port (like \Device\VCP0) to the port
+// name (like COM3).
+type serialCommEntry struct {
+	device string
+	port   string
+}
+
+func getSerialCommEntries() ([]serialCommEntry, error) {
 	subKey, err := syscall.UTF16PtrFromString("HARDWARE\\DEVICEMAP\\SERIALCOMM\\")
 	if err != nil {
 		return nil, &SerialPortError{code: ERROR_ENUMERATING_PORTS}
@@ -58,43 +167,483 @@ func GetPortsList() ([]string, error) {
 		return nil, &SerialPortError{code: ERROR_ENUMERATING_PORTS}
 	}
 
-	list := make([]string, valuesCount)
-	for i := range list {
-		var data [1024]uint16
-		dataSize := uint32(len(data))
-		var name [1024]uint16
-		nameSize := uint32(len(name))
-		if RegEnumValue(h, uint32(i), &name[0], &nameSize, nil, nil, &data[0], &dataSize) != nil {
-			return nil, &SerialPortError{code: ERROR_ENUMERATING_PORTS}
+	return readSerialCommEntries(valuesCount, func(index uint32, name []uint16, nameSize *uint32, data []uint16, dataSize *uint32) error {
+		return RegEnumValue(h, index, &name[0], nameSize, nil, nil, &data[0], dataSize)
+	})
+}
+
+// regEnumValueFunc reads a value of a registry key, like RegEnumValue
+type regEnumValueFunc func(index uint32, name []uint16, nameSize *uint32, data []uint16, dataSize *uint32) error
+
+// readSerialCommEntries reads the values of the SERIALCOMM key with
+// enumValue, growing the buffers when they are too small.
+func readSerialCommEntries(valuesCount uint32, enumValue regEnumValueFunc) ([]serialCommEntry, error) {
+	const ERROR_NO_MORE_ITEMS = syscall.Errno(259)
+
+	// The values may change while they are enumerated, so valuesCount is
+	// only a hint: the enumeration ends with ERROR_NO_MORE_ITEMS.
+	list := make([]serialCommEntry, 0, valuesCount)
+	for i := 0; ; i++ {
+		data := make([]uint16, 1024)
+		name := make([]uint16, 1024)
+		var dataSize, nameSize uint32
+		for {
+			dataSize = uint32(len(data) * 2) // in bytes
+			nameSize = uint32(len(name))     // in characters
+			err := enumValue(uint32(i), name, &nameSize, data, &dataSize)
+			if err == ERROR_NO_MORE_ITEMS {
+				return list, nil
+			}
+			if err == syscall.ERROR_MORE_DATA && len(data) < maxRegistryValueSize {
+				// The buffers are too small, grow them and retry
+				data = make([]uint16, len(data)*2)
+				name = make([]uint16, len(name)*2)
+				continue
+			}
+			if err != nil {
+				return nil, &SerialPortError{code: ERROR_ENUMERATING_PORTS}
+			}
+			break
 		}
-		list[i] = syscall.UTF16ToString(data[:])
+		// The data may not be null terminated, use only the returned
+		// sizes to avoid reading stale contents of the buffers
+		list = append(list, serialCommEntry{
+			device: syscall.UTF16ToString(name[:nameSize]),
+			port:   syscall.UTF16ToString(data[:dataSize/2]),
+		})
 	}
-	return list, nil
 }
 
+// maxRegistryValueSize limits the growth of the buffers used to read the
+// registry values (in characters)
+const maxRegistryValueSize = 64 * 1024
+
 func OpenPort(portName string, mode *Mode) (*SerialPort, error) {
-	p, err := openPort(portName, mode.BaudRate, time.Duration(mode.Vtimeout)*time.Millisecond)
+	port, err := openSerialPort(portName, &openOptions{mode: *mode})
+	return port, withPortName("open", portName, err)
+}
+
+func openSerialPort(portName string, opts *openOptions) (*SerialPort, error) {
+	mode := &opts.mode
+	timeouts := readTimeouts(time.Duration(mode.Vtimeout) * time.Millisecond)
+	applyMode := mode
+	if opts.preserveSettings {
+		applyMode = nil
+	}
+	p, err := openPort(portName, applyMode, opts.access, timeouts)
 	if err == nil {
 		port := new(SerialPort)
 		port.p = p
+		port.name = portName
+		port.mode = *mode
+		port.access = opts.access
+		port.probeSettings.Store(defaultDisconnectProbe)
+		port.timeouts = timeouts
+		port.preserveSettings = opts.preserveSettings
+		if port.preserveSettings {
+			if current, err := port.GetMode(); err == nil {
+				port.mode = *current
+			}
+		}
+		port.dtr = port.mode.FlowControl != FLOWCONTROL_DTRDSR
+		port.rts = true
+		watchLeak(port)
 		return port, err
 	}
 	return nil, err
 }
 
-func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
-	if len(name) > 0 && name[0] != '\\' {
-		name = "\\\\.\\" + name
+// openMode returns the Mode to apply when the port is reopened, or nil if
+// the settings of the port must be preserved
+func (port *SerialPort) openMode() *Mode {
+	if port.preserveSettings {
+		return nil
 	}
+	return &port.mode
+}
 
+// openAgain opens a new handle for the port. If the port has disappeared
+// and it's provided by an USB adapter, the adapter is looked up by serial
+// number to follow it under the new name that windows may have assigned
+// after the adapter was re-enumerated.
+func (port *SerialPort) openAgain() (*Port, error) {
+	p, err := openPort(port.name, port.openMode(), port.access, port.timeouts)
+	if err == nil {
+		return p, err
+	}
+	if port.serialNumber == "" {
+		// The registry keeps the name of the port under the device even
+		// when the adapter is unplugged
+		port.serialNumber = getUSBPorts()[shortPortName(port.name)].serialNumber
+	}
+	if port.serialNumber == "" {
+		return p, err
+	}
+	name, findErr := FindPortBySerialNumber(port.serialNumber)
+	if findErr != nil || name == shortPortName(port.name) {
+		return p, err
+	}
+	p, err = openPort(name, port.openMode(), port.access, port.timeouts)
+	if err == nil {
+		port.name = name
+	}
+	return p, err
+}
+
+// Reset closes and immediately reopens the serial port, using the same
+// port name and Mode it was opened with. This is useful to recover some
+// adapters from a wedged state. Reads that are pending while the port is
+// reset return an ERROR_PORT_RESET error. If the port can not be reopened
+// it is left closed and the error is returned.
+//
+// On windows a port provided by an USB adapter is reopened under its new
+// name if the adapter has been re-enumerated in the meantime.
+func (port *SerialPort) Reset() error {
+	port.pLock.Lock()
+	defer port.pLock.Unlock()
+	if port.p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	// Closing the handle aborts the pending overlapped operations
+	port.p.f.Close()
+	p, err := port.openAgain()
+	port.p = p
+	if err != nil {
+		forgetLeak(port)
+	}
+	atomic.StoreInt32(&port.faulted, 0)
+	return err
+}
+
+// reopen replaces the handle of the port with a new one. The old handle
+// must be closed first to open the device again, so if the device can not
+// be opened the port keeps the closed handle and remains faulted.
+func (port *SerialPort) reopen() error {
+	port.pLock.Lock()
+	defer port.pLock.Unlock()
+	if port.p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	port.p.f.Close()
+	p, err := port.openAgain()
+	if err != nil {
+		return err
+	}
+	port.p = p
+	return nil
+}
+
+// Set all parameters of the serial port. See the Mode structure for more
+// info.
+//
+// If the driver rejects the configuration, the settings are applied one at
+// a time to find out which one is invalid and the corresponding error is
+// returned (for example ERROR_INVALID_PORT_SPEED). The port is left open
+// with the previous configuration.
+//
+// SetMode can be called while a Read is waiting for data in another
+// goroutine: the pending read is suspended during the reconfiguration and
+// then resumed, without losing data and without returning errors.
+func (port *SerialPort) SetMode(mode *Mode) (err error) {
+	defer func() { err = withPortName("setmode", port.name, err) }()
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	err = p.reconfigure(func() error {
+		if err := setCommStateChecked(p.fd, mode); err != nil {
+			setCommState(p.fd, &port.mode)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return port.checkDisconnected(err)
+	}
+	port.mode = *mode
+	if err := port.checkAppliedMode(mode); err != nil {
+		return err
+	}
+	return port.restoreModemLines(mode)
+}
+
+// restoreModemLines sets again the DTR and RTS lines to the levels set
+// with SetDTR and SetRTS after SetCommState, that asserts both of them.
+// The lines driven by the flow control are not touched. The lines may
+// change for a moment while the settings are applied.
+func (port *SerialPort) restoreModemLines(mode *Mode) error {
+	if mode.FlowControl != FLOWCONTROL_DTRDSR && !port.dtr {
+		if err := port.SetDTR(false); err != nil {
+			return err
+		}
+	}
+	if mode.FlowControl != FLOWCONTROL_RTSCTS && !port.rts {
+		return port.SetRTS(false)
+	}
+	return nil
+}
+
+// reconfigure runs apply while no read is pending on the port, since
+// SetCommState must not be called during an overlapped ReadFile. The
+// pending read is canceled and read issues it again once apply is done.
+func (p *Port) reconfigure(apply func() error) error {
+	p.cl.Lock()
+	defer p.cl.Unlock()
+	atomic.AddUint32(&p.reconfigs, 1)
+	if err := syscall.CancelIoEx(p.fd, p.ro); err == nil {
+		// wait for the cancellation to complete
+		syscall.WaitForSingleObject(p.ro.HEvent, 100)
+	}
+	return apply()
+}
+
+// deadlineChanged cancels the pending reads and writes, that are issued
+// again with the new deadlines.
+func (port *SerialPort) deadlineChanged() {
+	p := port.current()
+	if p == nil {
+		return
+	}
+	p.cl.Lock()
+	defer p.cl.Unlock()
+	atomic.AddUint32(&p.deadlines, 1)
+	syscall.CancelIoEx(p.fd, p.ro)
+	syscall.CancelIoEx(p.fd, p.wo)
+}
+
+// GetMode returns the configuration currently applied to the serial port,
+// as reported by the driver.
+func (port *SerialPort) GetMode() (*Mode, error) {
+	p := port.current()
+	if p == nil {
+		return nil, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return nil, err
+	}
+	mode := &Mode{
+		BaudRate: int(params.BaudRate),
+		DataBits: int(params.ByteSize),
+		Parity:   Parity(params.Parity),
+		StopBits: StopBits(params.StopBits),
+		Vmin:     port.mode.Vmin,
+		Vtimeout: port.mode.Vtimeout,
+
+		StripParity:   port.mode.StripParity,
+		TranslateCRLF: port.mode.TranslateCRLF,
+	}
+	switch {
+	case params.flags&dcbOutXCTSFlow != 0:
+		mode.FlowControl = FLOWCONTROL_RTSCTS
+	case params.flags&dcbOutXDSRFlow != 0:
+		mode.FlowControl = FLOWCONTROL_DTRDSR
+	case params.flags&(dcbOutX|dcbInX) != 0:
+		mode.FlowControl = FLOWCONTROL_XONXOFF
+	}
+	return mode, nil
+}
+
+// ResetToDefaults restores the configuration of a port just opened with
+// an empty Mode: 9600_N81, no flow control, DTR and RTS asserted, break
+// cleared and no read timeout. The port is not closed.
+func (port *SerialPort) ResetToDefaults() error {
+	if err := port.SetMode(&Mode{}); err != nil {
+		return err
+	}
+	if err := port.SetReadTimeout(0); err != nil {
+		return err
+	}
+	if err := port.SetBreak(false); err != nil {
+		return err
+	}
+	if err := port.SetDTR(true); err != nil {
+		return err
+	}
+	return port.SetRTS(true)
+}
+
+// SetHardwareFlowControl enables or disables the RTS/CTS flow control,
+// without changing the other settings of the port.
+func (port *SerialPort) SetHardwareFlowControl(on bool) error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return err
+	}
+	params.flags &^= dcbOutXCTSFlow | dcbRTSControlMask
+	if on {
+		params.flags |= dcbOutXCTSFlow | dcbRTSControlHandshake
+	} else if port.rts {
+		params.flags |= dcbRTSControlEnable
+	}
+	if err := p.reconfigure(func() error { return setDCB(p.fd, params) }); err != nil {
+		return err
+	}
+	if on {
+		port.mode.FlowControl = FLOWCONTROL_RTSCTS
+	} else if port.mode.FlowControl == FLOWCONTROL_RTSCTS {
+		port.mode.FlowControl = FLOWCONTROL_NONE
+	}
+	return nil
+}
+
+// SetReadTimeout sets the maximum time a Read waits for incoming data,
+// when the timeout expires Read returns 0 bytes and no error. A timeout
+// of 0 or less makes Read wait until data is received.
+// SetReadTimeout replaces the timeouts set with SetTimeouts.
+func (port *SerialPort) SetReadTimeout(timeout time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	return port.setTimeouts(readTimeouts(timeout))
+}
+
+// SetReadWriteTimeouts sets the read timeout, as SetReadTimeout does, and
+// the total timeout of a Write at once, with a single SetCommTimeouts,
+// replacing all the timeouts set before. When the write timeout expires
+// Write returns the bytes sent so far and an ERROR_TIMEOUT error; a write
+// timeout of 0 or less makes Write wait until all the data is sent (the
+// default).
+func (port *SerialPort) SetReadWriteTimeouts(read, write time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	timeouts := readTimeouts(read)
+	if write > 0 {
+		timeouts.WriteTotalTimeoutConstant = timeoutMs(write)
+	}
+	return port.setTimeouts(timeouts)
+}
+
+// SetTimeouts sets the timeouts of Read and Write following the
+// COMMTIMEOUTS model of windows (see Timeouts), replacing the read timeout
+// set with SetReadTimeout. The values are passed as-is to the driver.
+func (port *SerialPort) SetTimeouts(t Timeouts) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	return port.setTimeouts(&structTimeouts{
+		ReadIntervalTimeout:         timeoutMs(t.ReadInterval),
+		ReadTotalTimeoutMultiplier:  timeoutMs(t.ReadTotalMultiplier),
+		ReadTotalTimeoutConstant:    timeoutMs(t.ReadTotalConstant),
+		WriteTotalTimeoutMultiplier: timeoutMs(t.WriteTotalMultiplier),
+		WriteTotalTimeoutConstant:   timeoutMs(t.WriteTotalConstant),
+	})
+}
+
+// SetInterByteTimeout makes Read return when no data is received for
+// the given time after the first byte, to detect the end of a packet. The
+// read timeout set with SetReadTimeout becomes the total timeout of a Read
+// (see Timeouts): Read waits for the first byte at most for the read
+// timeout, or forever if not set. A timeout of 0 disables the inter-byte
+// timeout, call SetReadTimeout to restore the plain read timeout.
+//
+// It sets the ReadIntervalTimeout of COMMTIMEOUTS. Without a read timeout
+// the port uses the special combination of MSDN with ReadIntervalTimeout
+// and ReadTotalTimeoutMultiplier set to MAXDWORD (ReadFile returns as soon
+// as one byte is available): in that case the total timeout is disabled,
+// since with a finite ReadIntervalTimeout and zero total timeouts ReadFile
+// waits for the first byte without limits, as wanted.
+func (port *SerialPort) SetInterByteTimeout(timeout time.Duration) error {
+	port.timeoutsLock.Lock()
+	defer port.timeoutsLock.Unlock()
+	t := *port.timeouts
+	if t.ReadIntervalTimeout == maxDWORD {
+		t.ReadTotalTimeoutMultiplier = 0
+		t.ReadTotalTimeoutConstant = 0
+	}
+	t.ReadIntervalTimeout = 0
+	if timeout > 0 {
+		t.ReadIntervalTimeout = timeoutMs(timeout)
+	}
+	return port.setTimeouts(&t)
+}
+
+func (port *SerialPort) setTimeouts(timeouts *structTimeouts) error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	if err := setCommTimeouts(p.fd, timeouts); err != nil {
+		return err
+	}
+	port.timeouts = timeouts
+	return nil
+}
+
+// current returns the Port currently in use, or nil if the port is closed
+func (port *SerialPort) current() *Port {
+	port.pLock.Lock()
+	defer port.pLock.Unlock()
+	return port.p
+}
+
+// markSpaceParity is true if PARITY_MARK and PARITY_SPACE are supported
+const markSpaceParity = true
+
+// dtrDsrFlowControl is true if FLOWCONTROL_DTRDSR is supported
+const dtrDsrFlowControl = true
+
+// validBaudRate reports if speed can be passed to the driver, that
+// accepts any baud rate (0 selects the default)
+func validBaudRate(speed int) bool {
+	return speed >= 0
+}
+
+// errSharingViolation (ERROR_SHARING_VIOLATION) is returned by CreateFile
+// if the port is opened by another process
+const errSharingViolation = syscall.Errno(32)
+
+// devicePrefix is the prefix of the names in the device namespace, needed
+// to open the ports above COM9, win32Prefix the prefix of the names in the
+// win32 file namespace, that can be used as well
+const devicePrefix = "\\\\.\\"
+const win32Prefix = "\\\\?\\"
+
+// devicePath returns the name of the port in the device namespace (for
+// example "\\.\COM3"), the names already in that form or in the "\\?\"
+// form are returned as-is.
+func devicePath(name string) string {
+	if strings.HasPrefix(name, devicePrefix) || strings.HasPrefix(name, win32Prefix) {
+		return name
+	}
+	return devicePrefix + name
+}
+
+// shortPortName returns the name of the port as returned by GetPortsList
+// (for example "COM3"), removing the "\\.\" or "\\?\" prefix
+func shortPortName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, devicePrefix), win32Prefix)
+}
+
+func openPort(name string, mode *Mode, access AccessMode, timeouts *structTimeouts) (p *Port, err error) {
+	name = devicePath(name)
+
+	var accessFlags uint32 = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	switch access {
+	case ACCESS_READ_ONLY:
+		accessFlags = syscall.GENERIC_READ
+	case ACCESS_WRITE_ONLY:
+		accessFlags = syscall.GENERIC_WRITE
+	}
 	h, err := syscall.CreateFile(syscall.StringToUTF16Ptr(name),
-		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		accessFlags,
 		0,
 		nil,
 		syscall.OPEN_EXISTING,
 		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
 		0)
 	if err != nil {
+		switch err {
+		case syscall.ERROR_FILE_NOT_FOUND, syscall.ERROR_PATH_NOT_FOUND:
+			return nil, &SerialPortError{code: ERROR_PORT_NOT_FOUND}
+		case errSharingViolation:
+			return nil, &SerialPortError{code: ERROR_PORT_BUSY}
+		case syscall.ERROR_ACCESS_DENIED:
+			// some drivers return it also if the port is in use
+			return nil, &SerialPortError{code: ERROR_PERMISSION_DENIED}
+		}
 		return nil, err
 	}
 	f := os.NewFile(uintptr(h), name)
@@ -104,13 +653,21 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		}
 	}()
 
-	if err = setCommState(h, baud); err != nil {
+	// CreateFile opens also devices that are not serial ports (like CON)
+	if _, err = getCommState(h); err != nil {
+		err = &SerialPortError{code: ERROR_NOT_A_SERIAL_PORT}
 		return
 	}
-	if err = setupComm(h, 64, 64); err != nil {
-		return
+
+	if mode != nil {
+		if err = setCommStateChecked(h, mode); err != nil {
+			return
+		}
+		if err = setupComm(h, 64, 64); err != nil {
+			return
+		}
 	}
-	if err = setCommTimeouts(h, readTimeout); err != nil {
+	if err = setCommTimeouts(h, timeouts); err != nil {
 		return
 	}
 	if err = setCommMask(h); err != nil {
@@ -134,52 +691,537 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 	return port, nil
 }
 
-func (p *SerialPort) Close() error {
-	return p.p.f.Close()
+// Close the serial port. The Reads and Writes waiting on the port are
+// aborted and return an ERROR_PORT_CLOSED error (see ErrPortClosed).
+func (port *SerialPort) Close() error {
+	port.pLock.Lock()
+	defer port.pLock.Unlock()
+	p := port.p
+	if p == nil {
+		return nil
+	}
+	// the pending operations see the port closed when they are aborted
+	port.p = nil
+	syscall.CancelIoEx(p.fd, nil)
+	err := p.f.Close()
+	forgetLeak(port)
+	return err
 }
 
-func (p *SerialPort) Write(buf []byte) (int, error) {
-	p.p.wl.Lock()
-	defer p.p.wl.Unlock()
+// write sends data to the serial port, preceding the NLs by a CR if
+// TranslateCRLF is set
+func (port *SerialPort) write(buf []byte) (int, error) {
+	if !port.mode.TranslateCRLF {
+		return port.writeOverlapped(buf)
+	}
+	out := bytes.Replace(buf, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+	sent, err := port.writeOverlapped(out)
+	if sent == len(out) {
+		return len(buf), err
+	}
+	// count the bytes of buf sent completely
+	n := 0
+	for size := 0; n < len(buf); n++ {
+		if buf[n] == '\n' {
+			size++
+		}
+		if size++; size > sent {
+			break
+		}
+	}
+	return n, err
+}
 
-	if err := resetEvent(p.p.wo.HEvent); err != nil {
-		return 0, err
+// writeOverlapped sends data to the serial port using overlapped i/o
+func (port *SerialPort) writeOverlapped(buf []byte) (int, error) {
+	p := port.current()
+	if p == nil {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	written := 0
+	for {
+		deadlines := atomic.LoadUint32(&p.deadlines)
+		if err := resetEvent(p.wo.HEvent); err != nil {
+			return written, err
+		}
+		var n uint32
+		err := syscall.WriteFile(p.fd, buf[written:], &n, p.wo)
+		if err != nil && err != syscall.ERROR_IO_PENDING {
+			return written + int(n), port.ioError(p, err)
+		}
+		m, err := getOverlappedResultDeadline(p.fd, p.wo, loadDeadline(&port.writeDeadline))
+		written += m
+		if err == syscall.ERROR_OPERATION_ABORTED && atomic.LoadUint32(&p.deadlines) != deadlines {
+			// canceled by deadlineChanged, send the rest with the new
+			// deadline
+			if written < len(buf) {
+				continue
+			}
+			return written, nil
+		}
+		if err != nil {
+			return written, port.ioError(p, err)
+		}
+		if written < len(buf) {
+			// the write timeout set with SetTimeouts expired
+			return written, &SerialPortError{code: ERROR_TIMEOUT}
+		}
+		return written, nil
+	}
+}
+
+// read receives data from the serial port
+func (port *SerialPort) read(buf []byte, opts readOptions) (n int, err error) {
+	if opts.timeoutSet {
+		n, err = port.readTimeout(buf, opts)
+	} else {
+		n, err = port.readMin(buf, opts.deadline)
+	}
+	if port.mode.StripParity {
+		// the driver delivers the bytes as received, strip them here
+		for i := 0; i < n; i++ {
+			buf[i] &= 0x7f
+		}
 	}
-	var n uint32
-	err := syscall.WriteFile(p.p.fd, buf, &n, p.p.wo)
-	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(n), err
+	if port.mode.TranslateCRLF {
+		for i := 0; i < n; i++ {
+			if buf[i] == '\r' {
+				buf[i] = '\n'
+			}
+		}
+	}
+	return n, err
+}
+
+// readTimeout reads with the timeout in opts in place of the timeouts of
+// the port, that can't be changed without affecting the other Reads and
+// Writes: the read is canceled when the timeout expires, and issued again
+// if the timeouts of the port expire first.
+func (port *SerialPort) readTimeout(buf []byte, opts readOptions) (int, error) {
+	var end time.Time
+	if opts.timeout > 0 {
+		end = time.Now().Add(opts.timeout)
+	}
+	for {
+		deadline := opts.deadline
+		if !end.IsZero() && (deadline.IsZero() || end.Before(deadline)) {
+			deadline = end
+		}
+		n, err := port.readMin(buf, deadline)
+		if serr, ok := err.(*SerialPortError); ok && serr.Timeout() && !end.IsZero() {
+			if d := port.readDeadlineOf(opts.deadline); d.IsZero() || end.Before(d) {
+				// the read timeout expired, that is not an error
+				return n, nil
+			}
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
 	}
-	return getOverlappedResult(p.p.fd, p.p.wo)
 }
 
-func (p *SerialPort) Read(buf []byte) (int, error) {
-	if p.p == nil || p.p.f == nil {
-		return 0, fmt.Errorf("Invalid port on read %v %v", p.p, p.p.f)
+// readMin reads waiting for at least minReadBytes, see SetMinReadBytes
+func (port *SerialPort) readMin(buf []byte, deadline time.Time) (int, error) {
+	min := int(atomic.LoadInt32(&port.minReadBytes))
+	port.timeoutsLock.Lock()
+	t := port.timeouts
+	port.timeoutsLock.Unlock()
+	if min <= 1 || t == nil {
+		return port.readOverlapped(buf, deadline)
 	}
+	if min < len(buf) {
+		buf = buf[:min]
+	}
+	switch {
+	case t.ReadIntervalTimeout == 0 && t.ReadTotalTimeoutMultiplier == 0:
+		// the driver already waits for the whole buffer or the timeout
+		return port.readOverlapped(buf, deadline)
+	case *t == *readTimeouts(0):
+		// blocking read, the driver returns as soon as one byte is available
+		n := 0
+		for n < len(buf) {
+			m, err := port.readOverlapped(buf[n:], deadline)
+			n += m
+			if err != nil || m == 0 {
+				return n, err
+			}
+		}
+		return n, nil
+	}
+	return port.readOverlapped(buf, deadline)
+}
+
+// readOverlapped receives data from the serial port using overlapped i/o,
+// until the deadline or the read deadline of the port (if not zero)
+func (port *SerialPort) readOverlapped(buf []byte, deadline time.Time) (int, error) {
+	p := port.current()
+	if p == nil || p.f == nil {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+
+	p.rl.Lock()
+	defer p.rl.Unlock()
 
-	p.p.rl.Lock()
-	defer p.p.rl.Unlock()
+	for {
+		p.cl.Lock()
+		reconfigs := atomic.LoadUint32(&p.reconfigs)
+		deadlines := atomic.LoadUint32(&p.deadlines)
+		if err := resetEvent(p.ro.HEvent); err != nil {
+			p.cl.Unlock()
+			return 0, err
+		}
+		var done uint32
+		err := syscall.ReadFile(p.fd, buf, &done, p.ro)
+		p.cl.Unlock()
+		if err != nil && err != syscall.ERROR_IO_PENDING {
+			return int(done), port.ioError(p, err)
+		}
+		n, err := getOverlappedResultDeadline(p.fd, p.ro, port.readDeadlineOf(deadline))
+		if err == syscall.ERROR_OPERATION_ABORTED && (atomic.LoadUint32(&p.reconfigs) != reconfigs || atomic.LoadUint32(&p.deadlines) != deadlines) {
+			// canceled by reconfigure or deadlineChanged, return the data
+			// received so far or issue the read again
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if err != nil {
+			return n, port.ioError(p, err)
+		}
+		return n, nil
+	}
+}
+
+// EnableOverrunDetection makes Read report the data lost because of an
+// overrun of the UART FIFO or of the driver buffer (see Read), as
+// reported by ClearCommError.
+func (port *SerialPort) EnableOverrunDetection(enable bool) error {
+	if !enable {
+		atomic.StoreInt32(&port.overrunDetection, 0)
+		return nil
+	}
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	// clear the errors happened so far
+	if _, _, err := clearCommError(p.fd); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&port.overrunDetection, 1)
+	return nil
+}
 
-	if err := resetEvent(p.p.ro.HEvent); err != nil {
+const (
+	CE_RXOVER  = 0x0001
+	CE_OVERRUN = 0x0002
+)
+
+// commStatus returns the status of the port. The overruns cleared by the
+// query are kept for the overrun detection of Read.
+func (port *SerialPort) commStatus(p *Port) (*structComStat, error) {
+	errors, stat, err := clearCommError(p.fd)
+	if err != nil {
+		return nil, err
+	}
+	if errors&(CE_RXOVER|CE_OVERRUN) != 0 {
+		atomic.AddInt64(&port.overruns, 1)
+	}
+	return stat, nil
+}
+
+// checkOverrun returns true if an overrun happened since the last check
+func (port *SerialPort) checkOverrun() bool {
+	p := port.current()
+	if p == nil {
+		return false
+	}
+	errors, _, err := clearCommError(p.fd)
+	lost := atomic.SwapInt64(&port.overruns, 0) > 0
+	return lost || (err == nil && errors&(CE_RXOVER|CE_OVERRUN) != 0)
+}
+
+// BufferSizes returns the size of the receive and transmit queues of the
+// driver, as reported by GetCommProperties. If the driver doesn't report
+// them an ERROR_NOT_SUPPORTED error is returned, as on the other
+// platforms; a single zero size means that only that one is unknown.
+func (port *SerialPort) BufferSizes() (rx, tx int, err error) {
+	p := port.current()
+	if p == nil {
+		return 0, 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	props, err := getCommProperties(p.fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	if props.CurrentRxQueue == 0 && props.CurrentTxQueue == 0 {
+		return 0, 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return int(props.CurrentRxQueue), int(props.CurrentTxQueue), nil
+}
+
+// baudRateFlags maps the BAUD_* flags of COMMPROP to the baud rates
+var baudRateFlags = []struct {
+	flag uint32
+	rate int
+}{
+	{0x00000001, 75},
+	{0x00000002, 110},
+	{0x00000004, 134},
+	{0x00000008, 150},
+	{0x00000010, 300},
+	{0x00000020, 600},
+	{0x00000040, 1200},
+	{0x00000080, 1800},
+	{0x00000100, 2400},
+	{0x00000200, 4800},
+	{0x00000400, 7200},
+	{0x00000800, 9600},
+	{0x00001000, 14400},
+	{0x00002000, 19200},
+	{0x00004000, 38400},
+	{0x00008000, 56000},
+	{0x00040000, 57600},
+	{0x00020000, 115200},
+	{0x00010000, 128000},
+}
+
+// MaxBaudRate returns the maximum baud rate of the port, as reported by
+// GetCommProperties. Many drivers report only that the baud rate is
+// programmable (BAUD_USER) without a maximum, in that case an
+// ERROR_NOT_SUPPORTED error is returned.
+func (port *SerialPort) MaxBaudRate() (int, error) {
+	p := port.current()
+	if p == nil {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	props, err := getCommProperties(p.fd)
+	if err != nil {
 		return 0, err
 	}
-	var done uint32
-	err := syscall.ReadFile(p.p.fd, buf, &done, p.p.ro)
-	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(done), err
+	max := maxBaudRate(props.MaxBaud)
+	if max == 0 {
+		return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return max, nil
+}
+
+// maxBaudRate returns the highest baud rate of the BAUD_* flags, or 0
+// if none is set
+func maxBaudRate(flags uint32) int {
+	max := 0
+	for _, b := range baudRateFlags {
+		if flags&b.flag != 0 && b.rate > max {
+			max = b.rate
+		}
+	}
+	return max
+}
+
+// Capabilities returns the settings supported by the port, as reported by
+// the driver with GetCommProperties.
+func (port *SerialPort) Capabilities() (PortCapabilities, error) {
+	const (
+		BAUD_USER = 0x10000000
+
+		PCF_DTRDSR  = 0x0001
+		PCF_RTSCTS  = 0x0002
+		PCF_XONXOFF = 0x0010
+
+		SP_STOPBITS_10  = 0x0001
+		SP_STOPBITS_15  = 0x0002
+		SP_STOPBITS_20  = 0x0004
+		SP_PARITY_NONE  = 0x0100
+		SP_PARITY_ODD   = 0x0200
+		SP_PARITY_EVEN  = 0x0400
+		SP_PARITY_MARK  = 0x0800
+		SP_PARITY_SPACE = 0x1000
+	)
+	p := port.current()
+	if p == nil {
+		return PortCapabilities{}, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	props, err := getCommProperties(p.fd)
+	if err != nil {
+		return PortCapabilities{}, err
+	}
+	caps := PortCapabilities{
+		AnyBaudRate: props.SettableBaud&BAUD_USER != 0,
+		MaxBaudRate: maxBaudRate(props.MaxBaud),
+		MaxRxQueue:  int(props.MaxRxQueue),
+		MaxTxQueue:  int(props.MaxTxQueue),
+	}
+	rates := []int{}
+	for _, b := range baudRateFlags {
+		if props.SettableBaud&b.flag != 0 {
+			rates = append(rates, b.rate)
+		}
+	}
+	sort.Ints(rates)
+	caps.BaudRates = rates
+	// DATABITS_5 to DATABITS_8 are the bits 0 to 3
+	for bits := 5; bits <= 8; bits++ {
+		if props.SettableData&(1<<uint(bits-5)) != 0 {
+			caps.DataBits = append(caps.DataBits, bits)
+		}
+	}
+	parities := []struct {
+		flag   uint16
+		parity Parity
+	}{
+		{SP_PARITY_NONE, PARITY_NONE},
+		{SP_PARITY_ODD, PARITY_ODD},
+		{SP_PARITY_EVEN, PARITY_EVEN},
+		{SP_PARITY_MARK, PARITY_MARK},
+		{SP_PARITY_SPACE, PARITY_SPACE},
+	}
+	for _, pp := range parities {
+		if props.SettableStopParity&pp.flag != 0 {
+			caps.Parities = append(caps.Parities, pp.parity)
+		}
+	}
+	stopBits := []struct {
+		flag     uint16
+		stopBits StopBits
+	}{
+		{SP_STOPBITS_10, STOPBITS_ONE},
+		{SP_STOPBITS_15, STOPBITS_ONEPOINTFIVE},
+		{SP_STOPBITS_20, STOPBITS_TWO},
+	}
+	for _, sb := range stopBits {
+		if props.SettableStopParity&sb.flag != 0 {
+			caps.StopBits = append(caps.StopBits, sb.stopBits)
+		}
+	}
+	caps.FlowControls = []FlowControl{FLOWCONTROL_NONE}
+	if props.ProvCapabilities&PCF_RTSCTS != 0 {
+		caps.FlowControls = append(caps.FlowControls, FLOWCONTROL_RTSCTS)
+	}
+	if props.ProvCapabilities&PCF_XONXOFF != 0 {
+		caps.FlowControls = append(caps.FlowControls, FLOWCONTROL_XONXOFF)
+	}
+	if props.ProvCapabilities&PCF_DTRDSR != 0 {
+		caps.FlowControls = append(caps.FlowControls, FLOWCONTROL_DTRDSR)
+	}
+	return caps, nil
+}
+
+// supportsRTSCTS reports if the driver supports the RTS/CTS flow control
+func (port *SerialPort) supportsRTSCTS() (bool, error) {
+	const PCF_RTSCTS = 0x0002
+	p := port.current()
+	if p == nil {
+		return false, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	props, err := getCommProperties(p.fd)
+	if err != nil {
+		return false, err
+	}
+	return props.ProvCapabilities&PCF_RTSCTS != 0, nil
+}
+
+// probe checks if the device is still responding
+func (port *SerialPort) probe() error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	_, err := getCommState(p.fd)
+	return err
+}
+
+// CancelIO aborts the pending Reads and Writes, making them return an
+// ERROR_CANCELED error. The port remains open and can be used again.
+func (port *SerialPort) CancelIO() error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	err := syscall.CancelIoEx(p.fd, nil)
+	if err != nil && err != syscall.ERROR_NOT_FOUND {
+		return err
+	}
+	return nil
+}
+
+// ioError translates the error of an operation issued on p, that was
+// aborted because the port has been closed, reset or canceled.
+func (port *SerialPort) ioError(p *Port, err error) error {
+	switch port.current() {
+	case p:
+		if err == syscall.ERROR_OPERATION_ABORTED {
+			return &SerialPortError{code: ERROR_CANCELED}
+		}
+		return err
+	case nil:
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	default:
+		return &SerialPortError{code: ERROR_PORT_RESET}
 	}
-	return getOverlappedResult(p.p.fd, p.p.ro)
 }
 
 // Discards data written to the port but not transmitted,
 // or data received but not read
-func (p *SerialPort) Flush() error {
-	return purgeComm(p.p.fd)
+func (port *SerialPort) Flush() error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	port.readBuffer.reset()
+	return purgeComm(p.fd)
+}
+
+// ResetInputBuffer discards the data received but not yet read. The data
+// written and not yet transmitted is not affected.
+func (port *SerialPort) ResetInputBuffer() error {
+	const PURGE_RXCLEAR = 0x0008
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	port.readBuffer.reset()
+	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(p.fd), PURGE_RXCLEAR, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// InputWaiting returns the number of bytes received and not yet read,
+// including the data kept by the read buffer (see SetReadBufferSize).
+func (port *SerialPort) InputWaiting() (int, error) {
+	p := port.current()
+	if p == nil {
+		return 0, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	stat, err := port.commStatus(p)
+	if err != nil {
+		return 0, err
+	}
+	return int(stat.InQueue) + port.readBuffer.buffered(), nil
+}
+
+// Drain waits until all the data written to the port has been
+// transmitted.
+func (port *SerialPort) Drain() error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	r, _, err := syscall.Syscall(nFlushFileBuffers, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
 }
 
 var (
 	nSetCommState,
+	nGetCommState,
 	nSetCommTimeouts,
 	nSetCommMask,
 	nSetupComm,
@@ -187,7 +1229,13 @@ var (
 	nCreateEvent,
 	nResetEvent,
 	nPurgeComm,
-	nFlushFileBuffers uintptr
+	nEscapeCommFunction,
+	nGetCommProperties,
+	nGetCommModemStatus,
+	nClearCommError,
+	nWaitCommEvent,
+	nFlushFileBuffers,
+	nWaitForMultipleObjects uintptr
 	modadvapi32       = syscall.NewLazyDLL("advapi32.dll")
 	procRegEnumValueW = modadvapi32.NewProc("RegEnumValueW")
 )
@@ -208,6 +1256,7 @@ func init() {
 	defer syscall.FreeLibrary(k32)
 
 	nSetCommState = getProcAddr(k32, "SetCommState")
+	nGetCommState = getProcAddr(k32, "GetCommState")
 	nSetCommTimeouts = getProcAddr(k32, "SetCommTimeouts")
 	nSetCommMask = getProcAddr(k32, "SetCommMask")
 	nSetupComm = getProcAddr(k32, "SetupComm")
@@ -215,7 +1264,13 @@ func init() {
 	nCreateEvent = getProcAddr(k32, "CreateEventW")
 	nResetEvent = getProcAddr(k32, "ResetEvent")
 	nPurgeComm = getProcAddr(k32, "PurgeComm")
+	nEscapeCommFunction = getProcAddr(k32, "EscapeCommFunction")
+	nGetCommProperties = getProcAddr(k32, "GetCommProperties")
+	nGetCommModemStatus = getProcAddr(k32, "GetCommModemStatus")
+	nClearCommError = getProcAddr(k32, "ClearCommError")
+	nWaitCommEvent = getProcAddr(k32, "WaitCommEvent")
 	nFlushFileBuffers = getProcAddr(k32, "FlushFileBuffers")
+	nWaitForMultipleObjects = getProcAddr(k32, "WaitForMultipleObjects")
 }
 
 func getProcAddr(lib syscall.Handle, name string) uintptr {
@@ -226,43 +1281,151 @@ func getProcAddr(lib syscall.Handle, name string) uintptr {
 	return addr
 }
 
-func setCommState(h syscall.Handle, baud int) error {
+// flags of the DCB structure
+const (
+	dcbBinary              = 0x00000001
+	dcbParity              = 0x00000002
+	dcbOutXCTSFlow         = 0x00000004
+	dcbOutXDSRFlow         = 0x00000008
+	dcbDTRControlEnable    = 0x00000010
+	dcbDTRControlHandshake = 0x00000020
+	dcbOutX                = 0x00000100
+	dcbInX                 = 0x00000200
+	dcbRTSControlEnable    = 0x00001000
+	dcbRTSControlHandshake = 0x00002000
+	dcbRTSControlMask      = 0x00003000
+)
+
+func setCommState(h syscall.Handle, mode *Mode) error {
 	var params structDCB
 	params.DCBlength = uint32(unsafe.Sizeof(params))
 
-	params.flags[0] = 0x01  // fBinary
-	params.flags[0] |= 0x10 // Assert DSR
+	params.flags = dcbBinary
+	switch mode.FlowControl {
+	case FLOWCONTROL_RTSCTS:
+		params.flags |= dcbDTRControlEnable | dcbOutXCTSFlow | dcbRTSControlHandshake
+	case FLOWCONTROL_XONXOFF:
+		params.flags |= dcbDTRControlEnable | dcbRTSControlEnable | dcbOutX | dcbInX
+		params.XonChar = 0x11
+		params.XoffChar = 0x13
+		params.XonLim = 2048
+		params.XoffLim = 512
+	case FLOWCONTROL_DTRDSR:
+		params.flags |= dcbDTRControlHandshake | dcbRTSControlEnable | dcbOutXDSRFlow
+	default:
+		params.flags |= dcbDTRControlEnable | dcbRTSControlEnable
+	}
+
+	if mode.Parity != PARITY_NONE {
+		params.flags |= dcbParity
+	}
 
-	params.BaudRate = uint32(baud)
-	params.ByteSize = 8
+	params.BaudRate = uint32(mode.BaudRate)
+	if params.BaudRate == 0 {
+		params.BaudRate = 9600 // Default to 9600
+	}
+	params.ByteSize = byte(mode.DataBits)
+	if params.ByteSize == 0 {
+		params.ByteSize = 8 // Default to 8 bits
+	}
+	// The PARITY_* and STOPBITS_* constants have the same values used
+	// in the DCB structure
+	params.Parity = byte(mode.Parity)
+	params.StopBits = byte(mode.StopBits)
 
-	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(&params)), 0)
+	return setDCB(h, &params)
+}
+
+func setDCB(h syscall.Handle, params *structDCB) error {
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(params)), 0)
 	if r == 0 {
 		return err
 	}
 	return nil
 }
 
-func setCommTimeouts(h syscall.Handle, readTimeout time.Duration) error {
-	var timeouts structTimeouts
-	const MAXDWORD = 1<<32 - 1
+func getCommState(h syscall.Handle) (*structDCB, error) {
+	params := &structDCB{}
+	params.DCBlength = uint32(unsafe.Sizeof(*params))
+	r, _, err := syscall.Syscall(nGetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(params)), 0)
+	if r == 0 {
+		return nil, err
+	}
+	return params, nil
+}
+
+// clearCommError returns and clears the communication errors (CE_* flags),
+// it returns also the status of the port
+func clearCommError(h syscall.Handle) (uint32, *structComStat, error) {
+	var errors uint32
+	var stat structComStat
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(h), uintptr(unsafe.Pointer(&errors)), uintptr(unsafe.Pointer(&stat)))
+	if r == 0 {
+		return 0, nil, err
+	}
+	return errors, &stat, nil
+}
+
+func getCommProperties(h syscall.Handle) (*structCommProp, error) {
+	props := &structCommProp{}
+	props.PacketLength = uint16(unsafe.Sizeof(*props))
+	r, _, err := syscall.Syscall(nGetCommProperties, 2, uintptr(h), uintptr(unsafe.Pointer(props)), 0)
+	if r == 0 {
+		return nil, err
+	}
+	return props, nil
+}
+
+// setCommStateChecked applies the mode like setCommState, but if the
+// driver rejects it, finds out which setting is invalid.
+func setCommStateChecked(h syscall.Handle, mode *Mode) error {
+	const ERROR_INVALID_PARAMETER = syscall.Errno(87)
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+	err := setCommState(h, mode)
+	if err == ERROR_INVALID_PARAMETER {
+		serr := isolateInvalidSetting(mode, func(m *Mode) error {
+			return setCommState(h, m)
+		})
+		if serr != nil {
+			return serr
+		}
+	}
+	return err
+}
 
+const maxDWORD = 1<<32 - 1
+
+// timeoutMs converts a duration to the milliseconds of COMMTIMEOUTS, a
+// negative duration is converted to MAXDWORD (see Timeouts)
+func timeoutMs(d time.Duration) uint32 {
+	if d < 0 {
+		return maxDWORD
+	}
+	ms := d.Nanoseconds() / 1e6
+	if ms < 1 && d > 0 {
+		ms = 1
+	} else if ms > maxDWORD-1 {
+		ms = maxDWORD - 1
+	}
+	return uint32(ms)
+}
+
+// readTimeouts returns the COMMTIMEOUTS implementing a read timeout as
+// described in SetReadTimeout
+func readTimeouts(readTimeout time.Duration) *structTimeouts {
+	timeouts := &structTimeouts{}
 	if readTimeout > 0 {
 		// non-blocking read
-		timeoutMs := readTimeout.Nanoseconds() / 1e6
-		if timeoutMs < 1 {
-			timeoutMs = 1
-		} else if timeoutMs > MAXDWORD {
-			timeoutMs = MAXDWORD
-		}
 		timeouts.ReadIntervalTimeout = 0
 		timeouts.ReadTotalTimeoutMultiplier = 0
-		timeouts.ReadTotalTimeoutConstant = uint32(timeoutMs)
+		timeouts.ReadTotalTimeoutConstant = timeoutMs(readTimeout)
 	} else {
 		// blocking read
-		timeouts.ReadIntervalTimeout = MAXDWORD
-		timeouts.ReadTotalTimeoutMultiplier = MAXDWORD
-		timeouts.ReadTotalTimeoutConstant = MAXDWORD - 1
+		timeouts.ReadIntervalTimeout = maxDWORD
+		timeouts.ReadTotalTimeoutMultiplier = maxDWORD
+		timeouts.ReadTotalTimeoutConstant = maxDWORD - 1
 	}
 
 	/* From http://msdn.microsoft.com/en-us/library/aa363190(v=VS.85).aspx
@@ -286,8 +1449,11 @@ func setCommTimeouts(h syscall.Handle, readTimeout time.Duration) error {
 		 If no bytes arrive within the time specified by
 		       ReadTotalTimeoutConstant, ReadFile times out.
 	*/
+	return timeouts
+}
 
-	r, _, err := syscall.Syscall(nSetCommTimeouts, 2, uintptr(h), uintptr(unsafe.Pointer(&timeouts)), 0)
+func setCommTimeouts(h syscall.Handle, timeouts *structTimeouts) error {
+	r, _, err := syscall.Syscall(nSetCommTimeouts, 2, uintptr(h), uintptr(unsafe.Pointer(timeouts)), 0)
 	if r == 0 {
 		return err
 	}
@@ -302,9 +1468,14 @@ func setupComm(h syscall.Handle, in, out int) error {
 	return nil
 }
 
+const (
+	EV_TXEMPTY = 0x0004
+	EV_RING    = 0x0100
+)
+
 func setCommMask(h syscall.Handle) error {
 	const EV_RXCHAR = 0x0001
-	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(h), EV_RXCHAR, 0)
+	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(h), EV_RXCHAR|EV_TXEMPTY|EV_RING, 0)
 	if r == 0 {
 		return err
 	}
@@ -355,6 +1526,425 @@ func getOverlappedResult(h syscall.Handle, overlapped *syscall.Overlapped) (int,
 	return n, nil
 }
 
-func (port *SerialPort) SetDTR(_ bool) error {
+// getOverlappedResultDeadline waits for the completion of an overlapped
+// operation like getOverlappedResult, but if the deadline (when not zero)
+// expires before the operation completes, the operation is canceled and
+// an ERROR_TIMEOUT error is returned together with the bytes transferred.
+func getOverlappedResultDeadline(h syscall.Handle, overlapped *syscall.Overlapped, deadline time.Time) (int, error) {
+	if !deadline.IsZero() {
+		timeout, _ := applyDeadline(0, deadline)
+		if timeout < 0 {
+			timeout = 0
+		}
+		event, err := syscall.WaitForSingleObject(overlapped.HEvent, uint32(timeout/time.Millisecond))
+		if err != nil {
+			return 0, err
+		}
+		if event == syscall.WAIT_TIMEOUT {
+			syscall.CancelIoEx(h, overlapped)
+			n, _ := getOverlappedResult(h, overlapped)
+			return n, &SerialPortError{code: ERROR_TIMEOUT}
+		}
+	}
+	return getOverlappedResult(h, overlapped)
+}
+
+// writev joins the buffers and sends them with a single WriteFile
+func (port *SerialPort) writev(bufs [][]byte) (int, error) {
+	return port.write(bytes.Join(bufs, nil))
+}
+
+// Set the DTR (Data Terminal Ready) line to the given level
+func (port *SerialPort) SetDTR(level bool) error {
+	const SETDTR = 5
+	const CLRDTR = 6
+	if err := port.escapeCommFunction(level, SETDTR, CLRDTR); err != nil {
+		return err
+	}
+	port.dtr = level
+	return nil
+}
+
+// Set the RTS (Request To Send) line to the given level
+func (port *SerialPort) SetRTS(level bool) error {
+	const SETRTS = 3
+	const CLRRTS = 4
+	if err := port.escapeCommFunction(level, SETRTS, CLRRTS); err != nil {
+		return err
+	}
+	port.rts = level
 	return nil
 }
+
+// ModemControlState returns the last levels set on the DTR and RTS output
+// lines (windows doesn't allow to read them back).
+func (port *SerialPort) ModemControlState() (dtr, rts bool) {
+	return port.dtr, port.rts
+}
+
+// SetBreak starts (true) or stops (false) sending a break condition
+func (port *SerialPort) SetBreak(on bool) error {
+	const SETBREAK = 8
+	const CLRBREAK = 9
+	if err := port.escapeCommFunction(on, SETBREAK, CLRBREAK); err != nil {
+		return port.checkDisconnected(err)
+	}
+	port.brk = on
+	return nil
+}
+
+// getModemStatus returns the level of an input line
+func (port *SerialPort) getModemStatus(sig Signal) (bool, error) {
+	const MS_CTS_ON = 0x0010
+	const MS_DSR_ON = 0x0020
+	const MS_RING_ON = 0x0040
+	const MS_RLSD_ON = 0x0080
+	var bit uint32
+	switch sig {
+	case SIGNAL_CTS:
+		bit = MS_CTS_ON
+	case SIGNAL_DSR:
+		bit = MS_DSR_ON
+	case SIGNAL_RI:
+		bit = MS_RING_ON
+	case SIGNAL_DCD:
+		bit = MS_RLSD_ON
+	default:
+		return false, &SerialPortError{code: ERROR_OTHER, err: "invalid signal"}
+	}
+	p := port.current()
+	if p == nil {
+		return false, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return false, err
+	}
+	return status&bit != 0, nil
+}
+
+func (port *SerialPort) escapeCommFunction(level bool, set, clr uintptr) error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	function := clr
+	if level {
+		function = set
+	}
+	r, _, err := syscall.Syscall(nEscapeCommFunction, 2, uintptr(p.fd), function, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// WaitForRing waits for a ring signal on the RI (Ring Indicator) line.
+// It returns ctx.Err() if ctx is canceled before the ring arrives.
+// Call it in a loop to count the rings.
+func (port *SerialPort) WaitForRing(ctx context.Context) error {
+	p := port.current()
+	if p == nil {
+		return &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	if err := p.acquireEventWait(); err != nil {
+		return err
+	}
+	defer p.releaseEventWait()
+	overlapped, err := newOverlapped()
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(overlapped.HEvent)
+	for {
+		events, err := port.waitCommEvent(ctx, p, overlapped)
+		if err != nil {
+			return err
+		}
+		if events&EV_RING != 0 {
+			return nil
+		}
+	}
+}
+
+// NotifyTxEmpty returns a channel signaled each time the transmit queue
+// of the driver becomes empty (EV_TXEMPTY), so that a streaming writer
+// can push more data without polling. A signal is dropped if the previous
+// one has not been received yet. The channel is closed when ctx is
+// canceled or the port is closed. The events are waited with
+// WaitCommEvent, so NotifyTxEmpty fails while Select or WaitForRing are
+// waiting on the same port, and they fail until ctx is canceled (see
+// acquireEventWait).
+func (port *SerialPort) NotifyTxEmpty(ctx context.Context) (<-chan struct{}, error) {
+	p := port.current()
+	if p == nil {
+		return nil, &SerialPortError{code: ERROR_PORT_CLOSED}
+	}
+	if err := p.acquireEventWait(); err != nil {
+		return nil, err
+	}
+	overlapped, err := newOverlapped()
+	if err != nil {
+		p.releaseEventWait()
+		return nil, err
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer p.releaseEventWait()
+		defer syscall.CloseHandle(overlapped.HEvent)
+		for {
+			mask, err := port.waitCommEvent(ctx, p, overlapped)
+			if err != nil {
+				return
+			}
+			if mask&EV_TXEMPTY != 0 {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// acquireEventWait reserves the comm events of p for the caller. Windows
+// allows a single pending WaitCommEvent per port, and a wait would steal
+// the events of another one: Select, WaitForRing and NotifyTxEmpty fail
+// if the port is already in use by one of them.
+func (p *Port) acquireEventWait() error {
+	if !atomic.CompareAndSwapInt32(&p.eventWait, 0, 1) {
+		return &SerialPortError{code: ERROR_OTHER, err: "the comm events of the port are already being waited"}
+	}
+	return nil
+}
+
+// releaseEventWait releases the comm events reserved by acquireEventWait
+func (p *Port) releaseEventWait() {
+	atomic.StoreInt32(&p.eventWait, 0)
+}
+
+// waitCommEvent waits for the next comm event on p with WaitCommEvent and
+// returns its mask. It returns ctx.Err() if ctx is canceled meanwhile.
+func (port *SerialPort) waitCommEvent(ctx context.Context, p *Port, overlapped *syscall.Overlapped) (uint32, error) {
+	var events uint32
+	r, _, errno := syscall.Syscall(nWaitCommEvent, 3, uintptr(p.fd), uintptr(unsafe.Pointer(&events)), uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 && errno != syscall.ERROR_IO_PENDING {
+		return 0, port.ioError(p, errno)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := getOverlappedResult(p.fd, overlapped)
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.CancelIoEx(p.fd, overlapped)
+		<-done
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, port.ioError(p, err)
+	}
+	return events, nil
+}
+
+// Select waits until at least one of the ports has received data, or the
+// timeout expires (a timeout <= 0 waits forever), and returns the indexes
+// in ports of those with data ready to be read. An empty list is returned
+// if the timeout expires. This allows to serve many ports from a single
+// goroutine. The ports with data in the read buffer (see
+// SetReadBufferSize) are ready too.
+//
+// On windows the ports are waited with WaitCommEvent, so Select fails if
+// WaitForRing or NotifyTxEmpty are waiting on one of the ports (see
+// acquireEventWait). At most 64 ports can be waited at the same time.
+func Select(ports []*SerialPort, timeout time.Duration) ([]int, error) {
+	const MAXIMUM_WAIT_OBJECTS = 64
+	if len(ports) == 0 {
+		return nil, &SerialPortError{code: ERROR_OTHER, err: "no ports to select"}
+	}
+	if len(ports) > MAXIMUM_WAIT_OBJECTS {
+		return nil, &SerialPortError{code: ERROR_OTHER, err: "too many ports to select"}
+	}
+	// a port listed more times is waited once
+	handles := make([]*Port, len(ports))
+	waited := []*Port{}
+	defer func() {
+		for _, p := range waited {
+			p.releaseEventWait()
+		}
+	}()
+	for i, port := range ports {
+		if handles[i] = port.current(); handles[i] == nil {
+			return nil, &SerialPortError{code: ERROR_PORT_CLOSED}
+		}
+		if containsPort(waited, handles[i]) {
+			continue
+		}
+		if err := handles[i].acquireEventWait(); err != nil {
+			return nil, err
+		}
+		waited = append(waited, handles[i])
+	}
+	if ready := bufferedPorts(ports); len(ready) > 0 {
+		return ready, nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		// The data already received doesn't raise EV_RXCHAR
+		ready := []int{}
+		for i, p := range handles {
+			stat, err := ports[i].commStatus(p)
+			if err != nil {
+				return nil, ports[i].ioError(p, err)
+			}
+			if stat.InQueue > 0 {
+				ready = append(ready, i)
+			}
+		}
+		if len(ready) > 0 {
+			return ready, nil
+		}
+		wait := uint32(syscall.INFINITE)
+		if timeout > 0 {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return ready, nil
+			}
+			wait = uint32(remaining / time.Millisecond)
+		}
+		if err := waitCommEvents(waited, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// containsPort reports if p is in handles
+func containsPort(handles []*Port, p *Port) bool {
+	for _, h := range handles {
+		if h == p {
+			return true
+		}
+	}
+	return false
+}
+
+// waitCommEvents waits until a comm event occurs on at least one of the
+// ports, or the timeout (in milliseconds) expires
+func waitCommEvents(handles []*Port, timeout uint32) error {
+	const WAIT_FAILED = 0xFFFFFFFF
+	events := make([]syscall.Handle, len(handles))
+	masks := make([]uint32, len(handles))
+	overlappeds := make([]*syscall.Overlapped, len(handles))
+	defer func() {
+		for i, o := range overlappeds {
+			if o == nil {
+				continue
+			}
+			syscall.CancelIoEx(handles[i].fd, o)
+			getOverlappedResult(handles[i].fd, o)
+			syscall.CloseHandle(o.HEvent)
+		}
+	}()
+	for i, p := range handles {
+		o, err := newOverlapped()
+		if err != nil {
+			return err
+		}
+		r, _, errno := syscall.Syscall(nWaitCommEvent, 3, uintptr(p.fd), uintptr(unsafe.Pointer(&masks[i])), uintptr(unsafe.Pointer(o)))
+		if r == 0 && errno != syscall.ERROR_IO_PENDING {
+			syscall.CloseHandle(o.HEvent)
+			return errno
+		}
+		overlappeds[i] = o
+		events[i] = o.HEvent
+		if r != 0 {
+			// completed immediately
+			return nil
+		}
+	}
+	r, _, err := syscall.Syscall6(nWaitForMultipleObjects, 4, uintptr(len(events)), uintptr(unsafe.Pointer(&events[0])), 0, uintptr(timeout), 0, 0)
+	if uint32(r) == WAIT_FAILED {
+		return err
+	}
+	return nil
+}
+
+// isHangup reports if err means that the device is certainly gone. On
+// windows the device is always probed (see SetDisconnectProbe).
+func isHangup(err error) bool {
+	return false
+}
+
+// OpenPTY is not supported on windows.
+func OpenPTY() (*SerialPort, string, error) {
+	return nil, "", &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetBreakHandling is not supported on windows: the driver never
+// delivers a break as data, it is reported only as a line error.
+func (port *SerialPort) SetBreakHandling(mode BreakMode) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetParityErrorReporting is not supported on windows.
+func (port *SerialPort) SetParityErrorReporting(enabled bool) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// GetLineDiscipline is not supported on windows.
+func (port *SerialPort) GetLineDiscipline() (int, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetLineDiscipline is not supported on windows.
+func (port *SerialPort) SetLineDiscipline(ldisc int) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// CapturePPS is not supported on windows.
+func (port *SerialPort) CapturePPS(ctx context.Context) (<-chan time.Time, error) {
+	return nil, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetLatencyTimer is not supported on windows: the latency timer of FTDI
+// adapters is a parameter of the driver, stored in the registry and
+// applied only when the device is plugged in.
+func (port *SerialPort) SetLatencyTimer(d time.Duration) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// ProbeBaudError is not supported on windows.
+func (port *SerialPort) ProbeBaudError(duration time.Duration) (float64, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetReceiveTriggerLevel is not supported on windows.
+func (port *SerialPort) SetReceiveTriggerLevel(n int) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// ReceiveTriggerLevel is not supported on windows.
+func (port *SerialPort) ReceiveTriggerLevel() (int, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// InterruptCounts is not supported on windows.
+func (port *SerialPort) InterruptCounts() (ICounts, error) {
+	return ICounts{}, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// SetControlChar is not supported on windows.
+func (port *SerialPort) SetControlChar(cc ControlChar, value byte) error {
+	return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
+
+// GetControlChar is not supported on windows.
+func (port *SerialPort) GetControlChar(cc ControlChar) (byte, error) {
+	return 0, &SerialPortError{code: ERROR_NOT_SUPPORTED}
+}
@@ -0,0 +1,89 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "reflect"
+import "strings"
+import "syscall"
+import "testing"
+
+func TestPortNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		devicePath string
+		shortName  string
+	}{
+		{"COM3", `\\.\COM3`, "COM3"},
+		{"COM42", `\\.\COM42`, "COM42"},
+		{`\\.\COM3`, `\\.\COM3`, "COM3"},
+		{`\\?\COM3`, `\\?\COM3`, "COM3"},
+	}
+	for _, test := range tests {
+		if path := devicePath(test.name); path != test.devicePath {
+			t.Errorf("devicePath(%q) = %q, want %q", test.name, path, test.devicePath)
+		}
+		if name := shortPortName(test.name); name != test.shortName {
+			t.Errorf("shortPortName(%q) = %q, want %q", test.name, name, test.shortName)
+		}
+	}
+}
+
+// fakeRegistry implements regEnumValueFunc over a list of values, the
+// values longer than maxSize characters never fit the buffers
+func fakeRegistry(values []serialCommEntry, maxSize int) regEnumValueFunc {
+	return func(index uint32, name []uint16, nameSize *uint32, data []uint16, dataSize *uint32) error {
+		if int(index) >= len(values) {
+			return syscall.Errno(259) // ERROR_NO_MORE_ITEMS
+		}
+		device := syscall.StringToUTF16(values[index].device)
+		port := syscall.StringToUTF16(values[index].port)
+		// the port name is stored without the terminator
+		port = port[:len(port)-1]
+		if len(device) > len(name) || len(port) > len(data) || len(port) > maxSize {
+			return syscall.ERROR_MORE_DATA
+		}
+		copy(name, device)
+		*nameSize = uint32(len(device) - 1)
+		copy(data, port)
+		*dataSize = uint32(len(port) * 2)
+		return nil
+	}
+}
+
+func TestReadSerialCommEntries(t *testing.T) {
+	values := []serialCommEntry{
+		{`\Device\Serial0`, "COM1"},
+		{`\Device\VCP0`, strings.Repeat("X", 5000)},
+		{`\Device\VCP1`, "COM10"},
+	}
+	tests := []struct {
+		count   uint32
+		values  []serialCommEntry
+		maxSize int
+		want    []serialCommEntry
+	}{
+		// fewer values than counted, as if removed meanwhile
+		{5, values, maxRegistryValueSize, values},
+		// more values than counted, as if added meanwhile
+		{1, values, maxRegistryValueSize, values},
+		{0, nil, maxRegistryValueSize, []serialCommEntry{}},
+		// a value that never fits the buffers
+		{3, values, 4000, nil},
+	}
+	for i, test := range tests {
+		list, err := readSerialCommEntries(test.count, fakeRegistry(test.values, test.maxSize))
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("test %d: got %d entries, want an error", i, len(list))
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(list, test.want) {
+			t.Errorf("test %d: got (%v, %v), want %v", i, list, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serialtest
+
+import "bytes"
+import "os"
+import "syscall"
+import "unsafe"
+
+const noctty = syscall.O_NOCTTY
+
+func openPTY() (*os.File, string, error) {
+	fd, err := syscall.Open("/dev/ptmx", syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := ioctl(fd, syscall.TIOCPTYGRANT, 0); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	if err := ioctl(fd, syscall.TIOCPTYUNLK, 0); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	var name [128]byte
+	if err := ioctl(fd, syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	// With a non blocking descriptor the file uses the runtime poller, so
+	// Close interrupts a pending Read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	if i := bytes.IndexByte(name[:], 0); i != -1 {
+		return master, string(name[:i]), nil
+	}
+	return master, string(name[:]), nil
+}
+
+func ioctl(fd int, req uint64, data uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
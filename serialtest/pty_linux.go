@@ -0,0 +1,47 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serialtest
+
+import "fmt"
+import "os"
+import "syscall"
+import "unsafe"
+
+const noctty = syscall.O_NOCTTY
+
+func openPTY() (*os.File, string, error) {
+	fd, err := syscall.Open("/dev/ptmx", syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var n uint32
+	if err := ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	var unlock int32
+	if err := ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	// With a non blocking descriptor the file uses the runtime poller, so
+	// Close interrupts a pending Read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, "", err
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+func ioctl(fd int, req uint64, data uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
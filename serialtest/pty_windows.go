@@ -0,0 +1,16 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serialtest
+
+import "errors"
+import "os"
+
+const noctty = 0
+
+func openPTY() (*os.File, string, error) {
+	return nil, "", errors.New("serialtest: virtual devices are not supported on windows")
+}
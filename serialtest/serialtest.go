@@ -0,0 +1,151 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package serialtest provides a scriptable virtual serial device, to test
+the code talking to a peripheral without the peripheral. The device is
+backed by a pseudo terminal, so the code under test opens it as any other
+serial port:
+
+	dev, err := serialtest.NewVirtualDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	dev.OnRequest([]byte("AT\r"), []byte("OK\r\n"))
+
+	port, err := serial.OpenPort(dev.Name(), &serial.Mode{BaudRate: 115200})
+	...
+
+The pseudo terminals are not available on windows.
+*/
+package serialtest
+
+import "bytes"
+import "os"
+import "sync"
+
+// maxPending is the amount of data received and not matched by any rule
+// after which the oldest data is discarded.
+const maxPending = 4096
+
+type rule struct {
+	pattern, response []byte
+}
+
+// VirtualDevice is a serial device that replies to the requests with
+// canned responses.
+type VirtualDevice struct {
+	master *os.File
+	slave  *os.File
+	name   string
+
+	lock    sync.Mutex
+	rules   []rule
+	pending []byte
+	done    chan struct{}
+}
+
+// NewVirtualDevice creates a virtual device. The device is ready to be
+// opened using the port name returned by Name.
+func NewVirtualDevice() (*VirtualDevice, error) {
+	master, name, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	// Keep the slave side open, otherwise the reads of the master fail
+	// while the port is not opened by the code under test
+	slave, err := os.OpenFile(name, os.O_RDWR|noctty, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	dev := &VirtualDevice{
+		master: master,
+		slave:  slave,
+		name:   name,
+		done:   make(chan struct{}),
+	}
+	go dev.serve()
+	return dev, nil
+}
+
+// Name returns the name of the serial port to open to talk to the device
+func (dev *VirtualDevice) Name() string {
+	return dev.name
+}
+
+// OnRequest adds a rule to the device: each time the pattern is received
+// the response is sent back. The data received is matched against the
+// rules in the order it arrives, the data preceding a match is discarded.
+// If more patterns match, the one that ends first wins, or the one added
+// first if they end at the same position.
+func (dev *VirtualDevice) OnRequest(pattern []byte, response []byte) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+	dev.rules = append(dev.rules, rule{
+		pattern:  append([]byte(nil), pattern...),
+		response: append([]byte(nil), response...),
+	})
+	dev.reply()
+}
+
+// Write sends unsolicited data from the device
+func (dev *VirtualDevice) Write(p []byte) (int, error) {
+	return dev.master.Write(p)
+}
+
+// Close closes the device. The port opened by the code under test is not
+// closed, but it stops receiving data.
+func (dev *VirtualDevice) Close() error {
+	err := dev.master.Close()
+	<-dev.done
+	dev.slave.Close()
+	return err
+}
+
+func (dev *VirtualDevice) serve() {
+	defer close(dev.done)
+	buf := make([]byte, 256)
+	for {
+		n, err := dev.master.Read(buf)
+		if err != nil {
+			return
+		}
+		dev.lock.Lock()
+		dev.pending = append(dev.pending, buf[:n]...)
+		dev.reply()
+		if len(dev.pending) > maxPending {
+			dev.pending = dev.pending[len(dev.pending)-maxPending:]
+		}
+		dev.lock.Unlock()
+	}
+}
+
+// reply sends the responses for the rules matching the pending data.
+// Must be called with the lock held.
+func (dev *VirtualDevice) reply() {
+	for {
+		end := -1
+		var match *rule
+		for i, r := range dev.rules {
+			if len(r.pattern) == 0 {
+				continue
+			}
+			if idx := bytes.Index(dev.pending, r.pattern); idx != -1 {
+				if e := idx + len(r.pattern); end == -1 || e < end {
+					end = e
+					match = &dev.rules[i]
+				}
+			}
+		}
+		if match == nil {
+			return
+		}
+		dev.pending = dev.pending[end:]
+		dev.master.Write(match.response)
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "time"
+
+// Signal identifies a control line of the serial port (see SetSignal and
+// GetSignal)
+type Signal int
+
+const (
+	SIGNAL_DTR   Signal = iota // Data Terminal Ready (output)
+	SIGNAL_RTS                 // Request To Send (output)
+	SIGNAL_BREAK               // Break condition on the transmit line (output)
+	SIGNAL_CTS                 // Clear To Send (input)
+	SIGNAL_DSR                 // Data Set Ready (input)
+	SIGNAL_RI                  // Ring Indicator (input)
+	SIGNAL_DCD                 // Data Carrier Detect (input)
+)
+
+// SetSignal sets the level of an output line (SIGNAL_DTR, SIGNAL_RTS or
+// SIGNAL_BREAK). The input lines can't be set.
+func (port *SerialPort) SetSignal(sig Signal, level bool) error {
+	switch sig {
+	case SIGNAL_DTR:
+		return port.SetDTR(level)
+	case SIGNAL_RTS:
+		return port.SetRTS(level)
+	case SIGNAL_BREAK:
+		return port.SetBreak(level)
+	}
+	return &SerialPortError{code: ERROR_OTHER, err: "the signal can not be set"}
+}
+
+// GetSignal returns the level of a line. The levels of the input lines
+// are read from the driver, for the output lines see ModemControlState.
+// On linux, if the UART driver doesn't support TIOCMGET, the input lines
+// are read from the cts, dsr, ri and dcd attributes of the tty in sysfs,
+// that are provided by the kernels of some embedded boards; the error of
+// the driver is returned if they are not available.
+func (port *SerialPort) GetSignal(sig Signal) (bool, error) {
+	switch sig {
+	case SIGNAL_DTR:
+		dtr, _ := port.ModemControlState()
+		return dtr, nil
+	case SIGNAL_RTS:
+		_, rts := port.ModemControlState()
+		return rts, nil
+	case SIGNAL_BREAK:
+		return port.brk, nil
+	}
+	return port.getModemStatus(sig)
+}
+
+// signalPollInterval is the interval at which the input lines are polled
+const signalPollInterval = 10 * time.Millisecond
+
+// AssertDTRAndWaitDSR raises the DTR line and waits until the device
+// acknowledges by raising the DSR line, as done by the power-up handshake
+// of many modems and instruments. The DSR line is polled every 10ms. If
+// DSR is not raised within the timeout an ERROR_TIMEOUT error is
+// returned, DTR is left asserted anyway.
+func (port *SerialPort) AssertDTRAndWaitDSR(timeout time.Duration) error {
+	if err := port.SetDTR(true); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		dsr, err := port.GetSignal(SIGNAL_DSR)
+		if err != nil {
+			return err
+		}
+		if dsr {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return &SerialPortError{code: ERROR_TIMEOUT}
+		}
+		time.Sleep(signalPollInterval)
+	}
+}
+
+// HasHardwareFlowControlLines tries to find out if the CTS and RTS lines
+// are wired, to warn that the RTS/CTS flow control can't work (for
+// example with the 3-wire cables). It's a heuristic: the lines are
+// considered wired if the driver reports the RTS/CTS flow control as
+// supported (windows only), and either CTS is asserted by the device or
+// it follows the toggling of RTS (as with a loopback plug or a null-modem
+// cable). A device that keeps CTS low until it's ready is reported as not
+// wired, so a false result should be taken only as a hint. RTS is toggled
+// for a few milliseconds and then restored.
+func (port *SerialPort) HasHardwareFlowControlLines() (bool, error) {
+	if supported, err := port.supportsRTSCTS(); err != nil || !supported {
+		return false, err
+	}
+	cts, err := port.GetSignal(SIGNAL_CTS)
+	if err != nil || cts {
+		return cts, err
+	}
+	_, rts := port.ModemControlState()
+	defer port.SetRTS(rts)
+	for _, level := range []bool{!rts, rts} {
+		if err := port.SetRTS(level); err != nil {
+			return false, err
+		}
+		time.Sleep(signalPollInterval)
+		cts, err := port.GetSignal(SIGNAL_CTS)
+		if err != nil {
+			return false, err
+		}
+		if cts != level {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// spinThreshold is the duration under which SendBreak busy-waits instead
+// of sleeping, the sleeps of the Go scheduler can overshoot by a
+// millisecond or more
+const spinThreshold = 2 * time.Millisecond
+
+// SendBreak transmits a break condition for the given duration. The break
+// is set and cleared with SetBreak: the durations shorter than 2ms are
+// timed with a busy wait, to obtain the short breaks needed by some
+// protocols (like the wake-up of LIN). The actual duration of the break
+// on the line is affected anyway by the latency of the driver: on native
+// UARTs it's in the order of tens of microseconds, while the USB adapters
+// set and clear the break with USB requests scheduled on 1ms frames (or
+// 125us frames with high speed USB), so the break may last up to a couple
+// of milliseconds more than requested.
+func (port *SerialPort) SendBreak(d time.Duration) error {
+	if err := port.SetBreak(true); err != nil {
+		return err
+	}
+	start := time.Now()
+	if d >= spinThreshold {
+		time.Sleep(d)
+	}
+	for time.Since(start) < d {
+		// busy wait
+	}
+	return port.SetBreak(false)
+}
+
+// SetLineInversion sets the inversion of the polarity of the RX and TX
+// lines, for the devices using inverted TTL levels. None of the supported
+// platforms allows to program it at run time: the serial drivers of linux,
+// darwin and windows don't have an interface for it, and the adapters
+// supporting the inversion (like the FTDI FT232R and FT-X series) store
+// it in their EEPROM, that must be programmed with the tools of the
+// vendor. So SetLineInversion returns ERROR_NOT_SUPPORTED, unless no
+// inversion is requested.
+func (port *SerialPort) SetLineInversion(rx, tx bool) error {
+	if rx || tx {
+		return &SerialPortError{code: ERROR_NOT_SUPPORTED}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "sync"
+import "sync/atomic"
+import "time"
+
+// LatencyBuckets are the upper bounds of the buckets of a LatencyHistogram
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram is the distribution of the durations of a kind of call.
+// Counts[i] is the number of calls that took less than LatencyBuckets[i]
+// (and more than the previous bucket), the last element of Counts is the
+// number of calls that took more than the last bucket.
+type LatencyHistogram struct {
+	Counts []uint64
+	Calls  uint64        // Total number of calls
+	Total  time.Duration // Sum of the durations of all the calls
+	Max    time.Duration // Longest call
+}
+
+// Mean returns the average duration of the calls
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Calls == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Calls)
+}
+
+// LatencyStats contains the distribution of the durations of the Read and
+// Write calls of a port, see EnableLatencyStats.
+type LatencyStats struct {
+	Read  LatencyHistogram
+	Write LatencyHistogram
+}
+
+type latencyRecorder struct {
+	read  histogramRecorder
+	write histogramRecorder
+}
+
+type histogramRecorder struct {
+	lock sync.Mutex
+	h    LatencyHistogram
+}
+
+func (r *histogramRecorder) record(start time.Time) {
+	d := time.Now().Sub(start)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.h.Counts == nil {
+		r.h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d >= LatencyBuckets[i] {
+		i++
+	}
+	r.h.Counts[i]++
+	r.h.Calls++
+	r.h.Total += d
+	if d > r.h.Max {
+		r.h.Max = d
+	}
+}
+
+func (r *histogramRecorder) snapshot() LatencyHistogram {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	h := r.h
+	h.Counts = append([]uint64(nil), r.h.Counts...)
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	return h
+}
+
+// EnableLatencyStats enables or disables the recording of the durations
+// of the Read and Write calls. It's disabled by default, when disabled
+// there is no overhead on the Read and Write calls.
+func (port *SerialPort) EnableLatencyStats(enable bool) {
+	if enable {
+		atomic.StoreInt32(&port.latencyEnabled, 1)
+	} else {
+		atomic.StoreInt32(&port.latencyEnabled, 0)
+	}
+}
+
+// LatencyStats returns the distribution of the durations of the Read and
+// Write calls made while the latency stats were enabled.
+func (port *SerialPort) LatencyStats() LatencyStats {
+	return LatencyStats{
+		Read:  port.latency.read.snapshot(),
+		Write: port.latency.write.snapshot(),
+	}
+}
+
+// ICounts are the counters of the events on a serial port kept by the
+// driver since the port was configured, see InterruptCounts.
+type ICounts struct {
+	RX, TX        int // Bytes received and transmitted
+	Frame         int // Framing errors
+	Overrun       int // Overruns of the UART FIFO
+	Parity        int // Parity errors
+	Break         int // Break conditions received
+	BufferOverrun int // Overruns of the driver buffer
+	CTS, DSR      int // Transitions of the CTS and DSR lines
+	RI, DCD       int // Transitions of the RI and DCD lines
+}
@@ -0,0 +1,51 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "io"
+
+// stream is the io.ReadWriteCloser returned by Stream
+type stream struct {
+	port *SerialPort
+}
+
+// Stream returns the port as a plain io.ReadWriteCloser, for the code
+// expecting a generic stream: Read blocks until some data is received,
+// retrying when the read timeout of the port expires, so it never returns
+// 0 bytes and no error, nor the ERROR_TIMEOUT error of SetReadTimeoutError
+// (an expired read deadline is still reported). Close waits for the data written to be
+// transmitted (see Drain) before closing the port.
+func (port *SerialPort) Stream() io.ReadWriteCloser {
+	return &stream{port: port}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		n, err := s.port.Read(p)
+		if s.port.isReadTimeout(err) {
+			continue
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	return s.port.Write(p)
+}
+
+func (s *stream) Close() error {
+	err := s.port.Drain()
+	if cerr := s.port.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
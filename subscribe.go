@@ -0,0 +1,112 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "context"
+import "sync"
+
+// subscriberQueue is the number of chunks buffered for each subscriber
+const subscriberQueue = 64
+
+// fanout distributes the data received by a port to its subscribers
+type fanout struct {
+	lock        sync.Mutex
+	subscribers map[chan []byte]struct{}
+	cancel      context.CancelFunc
+	done        chan struct{} // closed when the reading goroutine exits
+}
+
+// Subscribe returns a channel receiving a copy of every chunk of data
+// received by the port, so that many consumers can observe the same
+// stream, and a function to unsubscribe. A single goroutine reads the
+// port while there are subscribers: it's started by the first Subscribe
+// and stopped when the last subscriber leaves, meanwhile the port must not
+// be read by others.
+//
+// Each subscriber has a queue of 64 chunks: a slow subscriber doesn't
+// slow down the reception nor the other subscribers, when its queue is
+// full the new chunks are dropped for it. The channels are closed by the
+// unsubscribe function, or for all the subscribers when a Read fails (for
+// example because the port has been closed).
+func (port *SerialPort) Subscribe() (<-chan []byte, func()) {
+	f := &port.fanout
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ch := make(chan []byte, subscriberQueue)
+	if f.subscribers == nil {
+		f.subscribers = map[chan []byte]struct{}{}
+	}
+	f.subscribers[ch] = struct{}{}
+	if f.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		previous := f.done
+		f.cancel = cancel
+		f.done = make(chan struct{})
+		go port.runFanout(ctx, previous, f.done)
+	}
+	unsubscribe := func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if _, ok := f.subscribers[ch]; !ok {
+			return
+		}
+		delete(f.subscribers, ch)
+		close(ch)
+		if len(f.subscribers) == 0 && f.cancel != nil {
+			f.cancel()
+			f.cancel = nil
+		}
+	}
+	return ch, unsubscribe
+}
+
+// runFanout reads the port and distributes the data to the subscribers
+// until ctx is canceled or a Read fails. It waits for the goroutine of a
+// previous subscription to exit, so that its cancellation doesn't abort
+// the new Reads.
+func (port *SerialPort) runFanout(ctx context.Context, previous, done chan struct{}) {
+	defer close(done)
+	if previous != nil {
+		<-previous
+	}
+	f := &port.fanout
+	buf := make([]byte, 1024)
+	for {
+		n, err := port.ReadContext(ctx, buf)
+		if port.isReadTimeout(err) {
+			err = nil
+		}
+		f.lock.Lock()
+		if ctx.Err() != nil {
+			// the subscribers have left, the data read meanwhile is lost
+			f.lock.Unlock()
+			return
+		}
+		if n > 0 {
+			for ch := range f.subscribers {
+				chunk := make([]byte, n)
+				copy(chunk, buf[:n])
+				select {
+				case ch <- chunk:
+				default:
+					// the subscriber is not keeping up
+				}
+			}
+		}
+		if err != nil {
+			for ch := range f.subscribers {
+				close(ch)
+			}
+			f.subscribers = nil
+			f.cancel()
+			f.cancel = nil
+			f.lock.Unlock()
+			return
+		}
+		f.lock.Unlock()
+	}
+}
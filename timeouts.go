@@ -0,0 +1,71 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "sync/atomic"
+import "time"
+
+// Timeouts describes the timeouts of Read and Write following the
+// COMMTIMEOUTS model of windows, see SetTimeouts. The durations are
+// rounded to milliseconds, a zero duration disables the corresponding
+// timeout.
+type Timeouts struct {
+	// ReadInterval is the maximum time allowed between two bytes, once the
+	// first byte has been received. A negative value corresponds to the
+	// MAXDWORD value of windows: if ReadTotalMultiplier and
+	// ReadTotalConstant are zero Read returns immediately with the bytes
+	// already received, otherwise Read returns as soon as one byte is
+	// available.
+	ReadInterval time.Duration
+
+	// The total timeout of a Read is ReadTotalMultiplier multiplied by the
+	// number of requested bytes, plus ReadTotalConstant. When it expires
+	// Read returns the bytes received so far (possibly none) and no error.
+	ReadTotalMultiplier time.Duration
+	ReadTotalConstant   time.Duration
+
+	// The total timeout of a Write is WriteTotalMultiplier multiplied by
+	// the number of bytes to send, plus WriteTotalConstant. When it
+	// expires Write returns the bytes sent so far and an ERROR_TIMEOUT
+	// error.
+	WriteTotalMultiplier time.Duration
+	WriteTotalConstant   time.Duration
+}
+
+// SetMinReadBytes makes Read wait until n bytes have been received, or
+// the read timeout (see SetReadTimeout) expires, instead of returning as
+// soon as some data is available: this gives the "N bytes or timeout"
+// behavior wanted by the protocols with fixed size frames. Read returns
+// at most n bytes, or the bytes received so far when the timeout expires.
+// A value of 0 or 1 restores the default. The setting applies only to
+// the plain read timeout, it's ignored while the timeouts set with
+// SetTimeouts or SetInterByteTimeout are in use.
+func (port *SerialPort) SetMinReadBytes(n int) error {
+	if n < 0 {
+		return &SerialPortError{code: ERROR_OTHER, err: "Invalid minimum read size"}
+	}
+	atomic.StoreInt32(&port.minReadBytes, int32(n))
+	return nil
+}
+
+// ReadWithTimeout works like Read, but with the given read timeout (see
+// SetReadTimeout) in place of the timeouts of the port. The timeout
+// applies only to this call: the timeouts of the port are not changed, so
+// the Reads and Writes of other goroutines are not affected.
+func (port *SerialPort) ReadWithTimeout(p []byte, timeout time.Duration) (int, error) {
+	return port.readWith(p, readOptions{timeout: timeout, timeoutSet: true})
+}
+
+// readTotal returns the total timeout of a Read of n bytes
+func (t *Timeouts) readTotal(n int) time.Duration {
+	return t.ReadTotalConstant + time.Duration(n)*t.ReadTotalMultiplier
+}
+
+// writeTotal returns the total timeout of a Write of n bytes
+func (t *Timeouts) writeTotal(n int) time.Duration {
+	return t.WriteTotalConstant + time.Duration(n)*t.WriteTotalMultiplier
+}
@@ -0,0 +1,50 @@
+//
+// Copyright 2014 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "encoding/hex"
+import "fmt"
+import "io"
+import "sync"
+import "time"
+
+// tracer writes the hex dump of the data transferred through the port
+type tracer struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// EnableTracing writes a dump of all the data read from and written to
+// the port to w, in this format:
+//
+//	15:04:05.000000 TX 5 bytes
+//	00000000  48 65 6c 6c 6f                                    |Hello|
+//
+// The data returned by Read is not altered. A nil writer disables the
+// tracing (the default), when disabled there is no overhead on the Read
+// and Write calls. The writes to w are serialized.
+func (port *SerialPort) EnableTracing(w io.Writer) {
+	if w == nil {
+		port.tracer.Store((*tracer)(nil))
+	} else {
+		port.tracer.Store(&tracer{w: w})
+	}
+}
+
+// trace dumps the first n bytes of data to the tracer, if enabled
+func (port *SerialPort) trace(direction string, data []byte, n int) {
+	t, _ := port.tracer.Load().(*tracer)
+	if t == nil || n <= 0 {
+		return
+	}
+	data = data[:n]
+	ts := time.Now().Format("15:04:05.000000")
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	fmt.Fprintf(t.w, "%s %s %d bytes\n", ts, direction, len(data))
+	io.WriteString(t.w, hex.Dump(data))
+}